@@ -15,42 +15,113 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	_ "embed"
 
 	descpb "google.golang.org/protobuf/types/descriptorpb"
 
+	"github.com/bufbuild/protocompile"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
 
 	"github.com/protocolbuffers/protoscope"
 )
 
 var (
 	outPath  = flag.String("o", "", "output file to use (defaults to stdout)")
+	diffWith = flag.String("diff", "", "compare the input to the message in this file, printing the fields that differ by path, with -message-type if given")
+	outDir   = flag.String("out-dir", "", "when disassembling, write each input's output to a file in this directory named after it, with .txt appended")
+	concat   = flag.Bool("concat", false, "when disassembling several inputs, disassemble them concatenated rather than each on its own")
 	assemble = flag.Bool("s", false, "whether to treat the input as a Protoscope source file")
 	spec     = flag.Bool("spec", false, "opens the Protoscope spec in $PAGER")
+	check    = flag.Bool("check", false, "like -s, but only check the input for errors, reporting as many as possible, without producing output")
+	jsonDiag = flag.Bool("json", false, "with -check, output errors as a JSON array for editor integration")
+	format   = flag.Bool("fmt", false, "reformat the input, a Protoscope source file, with canonical spacing and indentation")
+	strict   = flag.Bool("strict", false, "with -s or -check, reject long-form:N and groups, which cannot appear in a canonical encoding")
+
+	canonicalBytes = flag.Bool("canonical-bytes", false, "re-encode the input with minimal varints, tags, and length prefixes, and output it as binary;\n"+
+		"note that this changes the bytes of any non-minimal encoding")
+
+	hexMode    = flag.Bool("hex", false, "with -s, output the assembled bytes as hex rather than binary; otherwise, read the input as hex, ignoring whitespace")
+	base64Mode = flag.Bool("base64", false, "with -s, output the assembled bytes as base64 rather than binary; otherwise, read the input as base64, ignoring whitespace")
+	hexGroup   = flag.Int("hex-group", 0, "with -hex, the number of bytes to print between spaces; 0 means no spaces")
+	hexLine    = flag.Int("hex-line", 0, "with -hex, the number of bytes to print per line; 0 means a single line")
+	outFormat  = flag.String("format", "binary", "with -s, how to output the assembled bytes: binary, or a literal in c, go, python, or rust; unrelated to -fmt")
+	cName      = flag.String("c-name", "", "with -format c, the name of a const unsigned char array to declare")
 
 	noQuotedStrings        = flag.Bool("no-quoted-strings", false, "assume no fields in the input proto are strings")
+	stringThreshold        = flag.Float64("string-threshold", 0, "the largest fraction of unprintable characters allowed in a string; 0 means the default of 0.3, and < 0 means no strings")
+	detectUTF16            = flag.Bool("detect-utf16", false, "also show hex fields that look like UTF-16LE text as strings, in comments")
 	allFieldsAreMessages   = flag.Bool("all-fields-are-messages", false, "try really hard to disassemble all fields as messages")
+	packedFields           = flag.Bool("packed-fields", false, "guess which length-prefixed fields are packed repeated fields")
+	annotateVarints        = flag.Bool("annotate-varints", false, "also show untyped multi-byte varints as zigzag and unsigned integers, in comments")
+	allVarintsZigzag       = flag.Bool("all-varints-zigzag", false, "assume all varints without a type in the schema are zigzag-encoded, like sint64")
 	explicitWireTypes      = flag.Bool("explicit-wire-types", false, "include an explicit wire type for every field")
+	numericWireTypes       = flag.Bool("numeric-wire-types", false, "like -explicit-wire-types, but print wire types as numbers")
 	noGroups               = flag.Bool("no-groups", false, "do not try to disassemble groups")
+	minimalVarints         = flag.Bool("minimal-varints", false, "never print long-form:N, so that reassembling produces minimal encodings")
+	sortFields             = flag.Bool("sort-fields", false, "print the fields of each message in order of field number; reassembling the output may then produce different bytes")
 	explicitLengthPrefixes = flag.Bool("explicit-length-prefixes", false, "emit literal length prefixes instead of braces")
+	fixedBoth              = flag.Bool("fixed-both-interpretations", false, "also show fixed-width fields printed as floats as integers, in comments")
+	showAltNumeric         = flag.Bool("show-alt-numeric", false, "like -fixed-both-interpretations, but also show fixed-width fields printed as integers as floats")
+	guessTimestamps        = flag.Bool("guess-timestamps", false, "show integers that look like Unix timestamps in seconds or milliseconds as times, in comments")
+	showTagBytes           = flag.Bool("show-tag-bytes", false, "show the bytes that make up each field's tag in a comment")
+	showOffsets            = flag.Bool("show-offsets", false, "show the offset in the input at which each field starts in a comment")
+	canonicalityReport     = flag.Bool("canonicality-report", false, "append a comment listing non-minimal encodings and duplicate non-repeated fields")
+	hexWidth               = flag.Int("hex-width", 0, "the number of bytes per line in hex literals; 0 means the default of 40")
+	stringWidth            = flag.Int("string-width", 0, "the number of bytes per line in quoted strings; 0 means the default of 80")
+	targetColumns          = flag.Int("target-columns", 0, "if positive, wrap hex literals, quoted strings, and packed fields to fit this many columns,\n"+
+		"overriding -hex-width and -string-width")
+	hexOffsets            = flag.String("hex-offsets", "none", "offsets to show beside each line of a hex literal: none, hex, dec, or hex-relative")
+	indentString          = flag.String("indent", "", "the string to indent each level with, such as a tab; defaults to two spaces")
+	maxFolds              = flag.Int("max-folds", 0, "the number of folded blocks a block may contain and still be folded onto one line; 0 means no limit")
+	noFold                = flag.Bool("no-fold", false, "never fold blocks onto one line")
+	commaSeparated        = flag.Bool("comma-separated", false, "separate the elements of packed fields with commas")
+	noAlignComments       = flag.Bool("no-align-comments", false, "print each comment directly after its line, rather than aligned with its neighbors")
+	color                 = flag.Bool("color", false, "highlight the output with ANSI escape codes, for reading in a terminal")
+	crlf                  = flag.Bool("crlf", false, "end each line of output with \\r\\n rather than \\n")
+	halfFloats            = flag.String("half-float-fields", "", "comma-separated field numbers of fixed-width or bytes fields to also show as 16-bit floats")
+	halfFloatFmt          = flag.String("half-float-format", "fp16", "the format used by -half-float-fields: fp16 or bf16")
+	commentEverything     = flag.Bool("comment-everything", false, "prefix every line of output with \"# \", for embedding in another Protoscope file")
+	messageRecursionDepth = flag.Int("message-recursion-depth", 0, "how many levels of length-prefixed fields may be printed as messages; 0 means no limit")
+	maxDepth              = flag.Int("max-depth", 0, "how many levels of length-prefixed fields may be looked inside of at all; deeper ones are printed as hex; 0 means no limit")
 
 	descriptorSet = flag.String("descriptor-set", "", "path to a file containing an encoded FileDescriptorSet, for aiding disassembly")
-	messageType   = flag.String("message-type", "", "full name of a type in the FileDescriptorSet given by -descriptor-set;\n"+
+	guessType     = flag.Bool("guess-type", false, "instead of -message-type, use whichever type in -descriptor-set or -proto the input decodes as best, noting which in a comment")
+	protoFile     = flag.String("proto", "", "path to a .proto file to compile and use instead of -descriptor-set")
+	protoPath     = flag.String("proto-path", "", "directories to search for the imports of -proto, separated like $PATH; defaults to the directory containing it")
+	messageType   = flag.String("message-type", "", "full name of a type in the FileDescriptorSet given by -descriptor-set or in -proto;\n"+
 		"the decoder will assume that the input file is an encoded binary proto\n"+
-		"of this type for the purposes of providing better output")
+		"of this type for the purposes of providing better output;\n"+
+		"with -s, fields may be named instead of numbered in the input")
 	printFieldNames = flag.Bool("print-field-names", false, "prints out field names, if using -message-type")
 	printEnumNames  = flag.Bool("print-enum-names", false, "prints out enum value names, if using -message-type")
+	fieldNames      = flag.String("field-names", "", "comma-separated path=name pairs naming fields by their dotted paths of field numbers, such as 1.2=id")
+	printOneofs     = flag.Bool("print-oneofs", false, "prints out the oneof of each field and warns about conflicting members, if using -message-type")
+	textProto       = flag.Bool("text-proto", false, "print the standard protobuf text format instead of Protoscope, using -message-type")
+	expandAny       = flag.Bool("expand-any", false, "prints google.protobuf.Any values as the type named by their type URL, if using -message-type")
+	focus           = flag.String("focus", "", "a dotted path of field numbers, or of field names if using -message-type,\n"+
+		"to descend along; only the fields at the end of the path are printed")
+
+	grpcFraming     = flag.Bool("grpc", false, "treat the input as a sequence of gRPC length-prefixed messages")
+	delimitedStream = flag.Bool("delimited", false, "treat the input as a sequence of messages each prefixed with a varint length")
+
+	statsRecursive = flag.Bool("stats-recursive", false, "appends comments profiling nesting depth, field counts, and field classifications")
+	statsMaxDepth  = flag.Int("stats-max-depth", 100, "maximum nesting depth descended into by -stats-recursive; < 0 means unlimited")
 )
 
 func main() {
@@ -62,7 +133,7 @@ func main() {
 
 func Main() error {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [-s] [OPTION...] [INPUT]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [-s] [OPTION...] [INPUT...]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Assemble a Protoscope file to binary, or inspect binary data as Protoscope text.\n")
 		fmt.Fprintf(os.Stderr, "Run with -spec to learn more about the Protoscope language.\n\n")
 		flag.PrintDefaults()
@@ -70,7 +141,10 @@ func Main() error {
 
 	flag.Parse()
 
-	if flag.NArg() > 1 {
+	// Assembly reads multiple inputs as if they had been concatenated, and
+	// disassembly does so with -concat, or else disassembles each one on its
+	// own.
+	if flag.NArg() > 1 && *format {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -79,7 +153,6 @@ func Main() error {
 		pager := os.Getenv("PAGER")
 		if pager == "" {
 			return fmt.Errorf("%s", protoscope.LanguageTxt)
-			return nil
 		}
 
 		cmd := exec.Command(pager)
@@ -91,86 +164,363 @@ func Main() error {
 		return nil
 	}
 
-	var schema protoreflect.MessageDescriptor
-	if *descriptorSet != "" || *messageType != "" {
-		if *assemble {
-			return errors.New("-message-type and -descriptor-set cannot be mixed with -s")
+	if *jsonDiag && !*check {
+		return errors.New("-json requires -check")
+	}
+	if *check {
+		*assemble = true
+	}
+
+	if *format && (*assemble || *canonicalBytes || *statsRecursive) {
+		return errors.New("-fmt cannot be mixed with -s, -check, -canonical-bytes, or -stats-recursive")
+	}
+
+	if (*concat || *outDir != "") && *assemble {
+		return errors.New("-concat and -out-dir cannot be mixed with -s or -check")
+	}
+	if *diffWith != "" && (*assemble || *format || flag.NArg() > 1 || *concat || *outDir != "") {
+		return errors.New("-diff takes a single input and cannot be mixed with -s, -check, -fmt, -concat, or -out-dir")
+	}
+	if *concat && *outDir != "" {
+		return errors.New("-concat cannot be mixed with -out-dir")
+	}
+	if *outDir != "" && *outPath != "" {
+		return errors.New("-out-dir cannot be mixed with -o")
+	}
+	if *canonicalBytes && flag.NArg() > 1 && !*concat && *outDir == "" {
+		return errors.New("-canonical-bytes with several inputs requires -concat or -out-dir")
+	}
+
+	if *hexMode && *base64Mode {
+		return errors.New("-hex cannot be mixed with -base64")
+	}
+	if (*hexMode || *base64Mode) && *format {
+		return errors.New("-fmt cannot be mixed with -hex or -base64")
+	}
+	switch *outFormat {
+	case "binary":
+	case "c", "go", "python", "rust":
+		if !*assemble {
+			return errors.New("-format requires -s")
 		}
-		if *descriptorSet == "" {
-			return errors.New("-message-type without -descriptor-set")
+		if *hexMode || *base64Mode {
+			return errors.New("-format cannot be mixed with -hex or -base64")
 		}
-		if *messageType == "" {
-			return errors.New("-descriptor-set without -message-type")
+	default:
+		return fmt.Errorf("unknown -format: %q", *outFormat)
+	}
+	if *cName != "" && *outFormat != "c" {
+		return errors.New("-c-name requires -format c")
+	}
+	if *strict && !*assemble {
+		return errors.New("-strict requires -s")
+	}
+
+	if *canonicalBytes && (*assemble || *statsRecursive) {
+		return errors.New("-canonical-bytes cannot be mixed with -s or -stats-recursive")
+	}
+
+	if *statsRecursive && *assemble {
+		return errors.New("-stats-recursive cannot be mixed with -s")
+	}
+
+	var hexOffsetStyle protoscope.HexOffsetStyle
+	switch *hexOffsets {
+	case "none":
+		hexOffsetStyle = protoscope.OffsetNone
+	case "hex":
+		hexOffsetStyle = protoscope.OffsetHexAbsolute
+	case "dec":
+		hexOffsetStyle = protoscope.OffsetDecAbsolute
+	case "hex-relative":
+		hexOffsetStyle = protoscope.OffsetHexRelative
+	default:
+		return fmt.Errorf("unknown -hex-offsets style: %q", *hexOffsets)
+	}
+
+	var halfFloatFields []int
+	if *halfFloats != "" {
+		for _, field := range strings.Split(*halfFloats, ",") {
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return fmt.Errorf("bad field number in -half-float-fields: %w", err)
+			}
+			halfFloatFields = append(halfFloatFields, n)
 		}
+	}
 
-		descBytes, err := os.ReadFile(*descriptorSet)
-		if err != nil {
-			return err
+	var fieldNameMap map[string]string
+	if *fieldNames != "" {
+		fieldNameMap = make(map[string]string)
+		for _, pair := range strings.Split(*fieldNames, ",") {
+			path, name, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("bad pair in -field-names: %q", pair)
+			}
+			fieldNameMap[path] = name
 		}
+	}
 
-		var fds descpb.FileDescriptorSet
-		if err := proto.Unmarshal(descBytes, &fds); err != nil {
-			return err
+	var halfFloatFormat protoscope.HalfFloatFormat
+	switch *halfFloatFmt {
+	case "fp16":
+		halfFloatFormat = protoscope.HalfFloatIEEE
+	case "bf16":
+		halfFloatFormat = protoscope.HalfFloatBfloat16
+	default:
+		return fmt.Errorf("unknown -half-float-format: %q", *halfFloatFmt)
+	}
+
+	var files *protoregistry.Files
+	var schema protoreflect.MessageDescriptor
+	var extensions *protoregistry.Types
+	if *descriptorSet != "" || *protoFile != "" || *messageType != "" {
+		if *descriptorSet != "" && *protoFile != "" {
+			return errors.New("-descriptor-set cannot be mixed with -proto")
+		}
+		if *descriptorSet == "" && *protoFile == "" {
+			return errors.New("-message-type without -descriptor-set or -proto")
+		}
+		if *messageType == "" && !*guessType {
+			return errors.New("-descriptor-set or -proto without -message-type or -guess-type")
+		}
+		if *messageType != "" && *guessType {
+			return errors.New("-message-type cannot be mixed with -guess-type")
+		}
+		if *guessType && *assemble {
+			return errors.New("-guess-type cannot be mixed with -s")
+		}
+		if *protoPath != "" && *protoFile == "" {
+			return errors.New("-proto-path without -proto")
 		}
 
-		files, err := protodesc.NewFiles(&fds)
+		var err error
+		if *protoFile != "" {
+			files, err = compileProto(*protoFile, *protoPath)
+		} else {
+			files, err = readDescriptorSet(*descriptorSet)
+		}
 		if err != nil {
 			return err
 		}
 
-		desc, err := files.FindDescriptorByName(protoreflect.FullName(*messageType))
+		if *messageType != "" {
+			desc, err := files.FindDescriptorByName(protoreflect.FullName(*messageType))
+			if err != nil {
+				return err
+			}
+
+			if msgDesc, ok := desc.(protoreflect.MessageDescriptor); ok {
+				schema = msgDesc
+			} else {
+				return fmt.Errorf("not a message type: %s", *messageType)
+			}
+		}
+
+		extensions, err = extensionTypes(files)
 		if err != nil {
 			return err
 		}
+	}
 
-		if msgDesc, ok := desc.(protoreflect.MessageDescriptor); ok {
-			schema = msgDesc
-		} else {
-			return fmt.Errorf("not a message type: %s", *messageType)
-		}
+	inPaths := flag.Args()
+	if len(inPaths) == 0 {
+		inPaths = []string{""}
 	}
+	inputs := make(map[string]string)
+	for _, inPath := range inPaths {
+		inFile := os.Stdin
+		if inPath != "" {
+			var err error
+			inFile, err = os.Open(inPath)
+			if err != nil {
+				return err
+			}
+			defer inFile.Close()
+		}
 
-	inPath := ""
-	inFile := os.Stdin
-	if flag.NArg() == 1 {
-		inPath = flag.Arg(0)
-		var err error
-		inFile, err = os.Open(inPath)
+		inBytes, err := io.ReadAll(inFile)
 		if err != nil {
 			return err
 		}
-		defer inFile.Close()
-	}
-
-	inBytes, err := io.ReadAll(inFile)
-	if err != nil {
-		return err
+		inputs[inPath] = string(inBytes)
 	}
+	inBytes := []byte(inputs[inPaths[0]])
 
 	var outBytes []byte
-	if *assemble {
+	var err error
+	if *format {
+		text, err := protoscope.Format(string(inBytes))
+		if err != nil {
+			var pe *protoscope.ParseError
+			if errors.As(err, &pe) {
+				return fmt.Errorf("syntax error: %s\n%s\n", err, pe.PrettyContext(string(inBytes), 2))
+			}
+			return fmt.Errorf("syntax error: %s\n", err)
+		}
+		outBytes = []byte(text)
+	} else if *assemble {
 		scanner := protoscope.NewScanner(string(inBytes))
-		scanner.SetFile(inPath)
+		scanner.SetFile(inPaths[0])
+		scanner.Schema = schema
+		scanner.Strict = *strict
+		for _, inPath := range inPaths[1:] {
+			scanner.AddFile(inPath, inputs[inPath])
+		}
+
+		if *jsonDiag {
+			diags := scanner.ValidateJSON()
+			if err := writeOutput(append(diags, '\n')); err != nil {
+				return err
+			}
+			if string(diags) != "[]" {
+				return errors.New("the input has errors")
+			}
+			return nil
+		}
+
+		if *check {
+			_, errs := scanner.ExecAll()
+			if len(errs) == 0 {
+				return nil
+			}
+			var msg strings.Builder
+			for _, pe := range errs {
+				fmt.Fprintf(&msg, "syntax error: %s\n%s\n", pe, pe.PrettyContext(inputs[pe.Pos.File], 2))
+			}
+			return errors.New(msg.String())
+		}
 
 		outBytes, err = scanner.Exec()
 		if err != nil {
+			var pe *protoscope.ParseError
+			if errors.As(err, &pe) {
+				return fmt.Errorf("syntax error: %s\n%s\n", err, pe.PrettyContext(inputs[pe.Pos.File], 2))
+			}
 			return fmt.Errorf("syntax error: %s\n", err)
-			os.Exit(1)
+		}
+
+		if *hexMode {
+			outBytes = formatHex(outBytes, *hexGroup, *hexLine)
+		}
+		if *base64Mode {
+			outBytes = []byte(base64.StdEncoding.EncodeToString(outBytes) + "\n")
+		}
+		switch *outFormat {
+		case "binary":
+		case "c":
+			outBytes = []byte(protoscope.CArray(outBytes, *cName) + "\n")
+		default:
+			literal, err := protoscope.EncodeLiteral(outBytes, *outFormat)
+			if err != nil {
+				return err
+			}
+			outBytes = []byte(literal + "\n")
 		}
 	} else {
-		outBytes = []byte(protoscope.Write(inBytes, protoscope.WriterOptions{
+		opts := protoscope.WriterOptions{
 			NoQuotedStrings:        *noQuotedStrings,
+			StringThreshold:        *stringThreshold,
+			DetectUTF16:            *detectUTF16,
 			AllFieldsAreMessages:   *allFieldsAreMessages,
 			ExplicitWireTypes:      *explicitWireTypes,
+			NumericWireTypes:       *numericWireTypes,
 			NoGroups:               *noGroups,
 			ExplicitLengthPrefixes: *explicitLengthPrefixes,
+			MinimalVarints:         *minimalVarints,
+			SortFields:             *sortFields,
+			PackedFields:           *packedFields,
+			AnnotateVarints:        *annotateVarints,
+			AllVarintsZigzag:       *allVarintsZigzag,
+
+			ShowTagBytes:             *showTagBytes,
+			ShowOffsets:              *showOffsets,
+			CanonicalityReport:       *canonicalityReport,
+			HexWidth:                 *hexWidth,
+			StringWidth:              *stringWidth,
+			TargetColumns:            *targetColumns,
+			HexOffsetStyle:           hexOffsetStyle,
+			IndentString:             *indentString,
+			MaxFolds:                 *maxFolds,
+			NoFold:                   *noFold,
+			NoAlignComments:          *noAlignComments,
+			CommaSeparated:           *commaSeparated,
+			Color:                    *color,
+			CRLF:                     *crlf,
+			CommentEverything:        *commentEverything,
+			HalfFloatFields:          halfFloatFields,
+			HalfFloatFormat:          halfFloatFormat,
+			FixedBothInterpretations: *fixedBoth,
+			ShowAltNumeric:           *showAltNumeric,
+			GuessTimestamps:          *guessTimestamps,
+			MessageRecursionDepth:    *messageRecursionDepth,
+			MaxDepth:                 *maxDepth,
+
+			Schema:            schema,
+			ExtensionRegistry: extensions,
+			PrintFieldNames:   *printFieldNames,
+			PrintEnumNames:    *printEnumNames,
+			PrintOneofs:       *printOneofs,
+			FieldNames:        fieldNameMap,
+			ExpandAny:         *expandAny,
+			Files:             files,
+			GRPCFraming:       *grpcFraming,
+			DelimitedStream:   *delimitedStream,
+		}
+
+		if *diffWith != "" {
+			return printDiff(inputs[inPaths[0]], *diffWith, opts)
+		}
 
-			Schema:          schema,
-			PrintFieldNames: *printFieldNames,
-			PrintEnumNames:  *printEnumNames,
-		}))
+		if *concat || len(inPaths) == 1 && *outDir == "" {
+			var src []byte
+			for _, inPath := range inPaths {
+				data, err := decodeInput(inputs[inPath])
+				if err != nil {
+					return err
+				}
+				src = append(src, data...)
+			}
+			outBytes, err = disassemble(src, opts)
+			if err != nil {
+				return err
+			}
+		} else {
+			// Disassemble each input separately, into its own section of the output
+			// or its own file.
+			for i, inPath := range inPaths {
+				src, err := decodeInput(inputs[inPath])
+				if err != nil {
+					return fmt.Errorf("%s: %w", inPath, err)
+				}
+				text, err := disassemble(src, opts)
+				if err != nil {
+					return fmt.Errorf("%s: %w", inPath, err)
+				}
+
+				if *outDir != "" {
+					if err := os.WriteFile(filepath.Join(*outDir, filepath.Base(inPath)+".txt"), text, 0666); err != nil {
+						return err
+					}
+					continue
+				}
+				if i > 0 {
+					outBytes = append(outBytes, '\n')
+				}
+				outBytes = append(outBytes, "# "+inPath+"\n"...)
+				outBytes = append(outBytes, text...)
+			}
+			if *outDir != "" {
+				return nil
+			}
+		}
 	}
 
+	return writeOutput(outBytes)
+}
+
+// writeOutput writes out to the file named by -o, or to stdout.
+func writeOutput(out []byte) error {
 	outFile := os.Stdout
 	if *outPath != "" {
 		var err error
@@ -181,6 +531,307 @@ func Main() error {
 		defer outFile.Close()
 	}
 
-	_, err = outFile.Write(outBytes)
+	_, err := outFile.Write(out)
 	return err
 }
+
+// disassemble disassembles src as the various flags ask, using opts for
+// everything but the flags that change what kind of output is produced.
+func disassemble(src []byte, opts protoscope.WriterOptions) ([]byte, error) {
+	// The note on which type was guessed goes before the disassembly.
+	var guessNote string
+	if *guessType {
+		guesses := protoscope.GuessMessageType(src, opts.Files)
+		if len(guesses) == 0 {
+			return nil, errors.New("-guess-type: the input does not decode as any message type")
+		}
+		opts.Schema = guesses[0].Type
+		guessNote = describeGuess(guesses)
+	}
+	schema := opts.Schema
+
+	if *focus != "" {
+		var err error
+		opts.FocusPath, err = protoscope.ResolveFocusPath(schema, *focus)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var outBytes []byte
+	if *textProto {
+		if schema == nil {
+			return nil, errors.New("-text-proto without -message-type")
+		}
+		text, err := protoscope.WriteTextProto(src, schema)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse input as %s: %w", schema.FullName(), err)
+		}
+		outBytes = []byte(text)
+	} else if *canonicalBytes {
+		// Reassemble the disassembly without any long-form:N, which produces
+		// the minimal encoding.
+		opts.MinimalVarints = true
+		opts.ExplicitLengthPrefixes = false
+		var err error
+		outBytes, err = protoscope.Assemble(protoscope.Write(src, opts))
+		if err != nil {
+			return nil, fmt.Errorf("could not reassemble input: %w", err)
+		}
+	} else {
+		outBytes = protoscope.AppendWrite(nil, src, opts)
+	}
+
+	if *statsRecursive {
+		stats := protoscope.ComputeStats(src, *statsMaxDepth)
+		outBytes = append(outBytes, stats.String()...)
+	}
+	if !*canonicalBytes {
+		outBytes = append([]byte(guessNote), outBytes...)
+	}
+	return outBytes, nil
+}
+
+// printDiff prints the differences between the message in input and the one
+// in the file at path, with one line per field.
+func printDiff(input, path string, opts protoscope.WriterOptions) error {
+	a, err := decodeInput(input)
+	if err != nil {
+		return err
+	}
+	other, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	b, err := decodeInput(string(other))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	diffs, err := protoscope.Diff(a, b, opts)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		out, err = os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	for _, d := range diffs {
+		if _, err := fmt.Fprintln(out, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeGuess describes the best of guesses, which is not empty, in a comment
+// saying how sure it is.
+func describeGuess(guesses []protoscope.TypeGuess) string {
+	best := guesses[0]
+	note := fmt.Sprintf("# guessed type: %s (%d of %d fields known", best.Type.FullName(), best.Known, best.Known+best.Unknown)
+	if len(guesses) > 1 {
+		next := guesses[1]
+		if next.Known == best.Known && next.Unknown == best.Unknown {
+			note += fmt.Sprintf("; ambiguous with %s", next.Type.FullName())
+		} else {
+			note += fmt.Sprintf("; next best: %s, %d of %d", next.Type.FullName(), next.Known, next.Known+next.Unknown)
+		}
+	}
+	return note + ")\n"
+}
+
+// readDescriptorSet reads the encoded FileDescriptorSet at path.
+func readDescriptorSet(path string) (*protoregistry.Files, error) {
+	descBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fds descpb.FileDescriptorSet
+	if err := proto.Unmarshal(descBytes, &fds); err != nil {
+		return nil, err
+	}
+	return protodesc.NewFiles(&fds)
+}
+
+// compileProto compiles the .proto file at path, along with its imports,
+// which are searched for in the directories listed in importPath. The
+// well-known types are always available.
+func compileProto(path, importPath string) (*protoregistry.Files, error) {
+	var dirs []string
+	if importPath != "" {
+		dirs = filepath.SplitList(importPath)
+	} else {
+		dirs = []string{filepath.Dir(path)}
+	}
+
+	// The compiler wants the file's name relative to one of the import paths,
+	// which is also how other files would import it.
+	name := path
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = filepath.ToSlash(rel)
+			break
+		}
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: dirs}),
+	}
+	compiled, err := compiler.Compile(context.Background(), name)
+	if err != nil {
+		// Errors already begin with the location they occurred at.
+		return nil, fmt.Errorf("could not compile %s:\n%w", path, err)
+	}
+
+	files := new(protoregistry.Files)
+	var register func(fd protoreflect.FileDescriptor) error
+	register = func(fd protoreflect.FileDescriptor) error {
+		if _, err := files.FindFileByPath(fd.Path()); err == nil {
+			return nil
+		}
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if err := register(imports.Get(i).FileDescriptor); err != nil {
+				return err
+			}
+		}
+		return files.RegisterFile(fd)
+	}
+	for _, fd := range compiled {
+		if err := register(fd); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// extensionTypes builds a registry of every extension declared in files.
+func extensionTypes(files *protoregistry.Files) (*protoregistry.Types, error) {
+	types := new(protoregistry.Types)
+	var register func(xds protoreflect.ExtensionDescriptors, msgs protoreflect.MessageDescriptors) error
+	register = func(xds protoreflect.ExtensionDescriptors, msgs protoreflect.MessageDescriptors) error {
+		for i := 0; i < xds.Len(); i++ {
+			if err := types.RegisterExtension(dynamicpb.NewExtensionType(xds.Get(i))); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < msgs.Len(); i++ {
+			if err := register(msgs.Get(i).Extensions(), msgs.Get(i).Messages()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		err = register(fd.Extensions(), fd.Messages())
+		return err == nil
+	})
+	return types, err
+}
+
+// formatHex formats data as lowercase hex, with a space after every group
+// bytes and a newline after every line bytes. Either may be zero to disable
+// the corresponding separator.
+func formatHex(data []byte, group, line int) []byte {
+	var out strings.Builder
+	for i, b := range data {
+		if i > 0 {
+			if line > 0 && i%line == 0 {
+				out.WriteString("\n")
+			} else if group > 0 && i%group == 0 {
+				out.WriteString(" ")
+			}
+		}
+		fmt.Fprintf(&out, "%02x", b)
+	}
+	out.WriteString("\n")
+	return []byte(out.String())
+}
+
+// decodeInput decodes input to be disassembled, if -hex or -base64 says it is
+// not binary.
+func decodeInput(src string) ([]byte, error) {
+	switch {
+	case *hexMode:
+		return decodeHex(src)
+	case *base64Mode:
+		return decodeBase64(src)
+	default:
+		return []byte(src), nil
+	}
+}
+
+// decodeHex decodes hex input, ignoring whitespace.
+func decodeHex(src string) ([]byte, error) {
+	var out []byte
+	var hi byte
+	var half bool
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		var nibble byte
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			continue
+		case '0' <= c && c <= '9':
+			nibble = c - '0'
+		case 'a' <= c && c <= 'f':
+			nibble = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			nibble = c - 'A' + 10
+		default:
+			return nil, fmt.Errorf("invalid hex input: unexpected %q at offset %d", c, i)
+		}
+
+		if half {
+			out = append(out, hi<<4|nibble)
+		}
+		hi, half = nibble, !half
+	}
+	if half {
+		return nil, errors.New("invalid hex input: odd number of digits")
+	}
+	return out, nil
+}
+
+// decodeBase64 decodes standard or URL-safe base64 input, with or without
+// padding, ignoring whitespace.
+func decodeBase64(src string) ([]byte, error) {
+	// Strip whitespace, remembering where each remaining byte came from, so
+	// that errors can point into the original input.
+	var text []byte
+	var offsets []int
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		text = append(text, src[i])
+		offsets = append(offsets, i)
+	}
+
+	enc := base64.StdEncoding
+	if strings.ContainsAny(string(text), "-_") {
+		enc = base64.URLEncoding
+	}
+	if len(text)%4 != 0 {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	out, err := enc.DecodeString(string(text))
+	var corrupt base64.CorruptInputError
+	if errors.As(err, &corrupt) {
+		offset := len(src)
+		if int(corrupt) < len(offsets) {
+			offset = offsets[corrupt]
+		}
+		return nil, fmt.Errorf("invalid base64 input at offset %d", offset)
+	}
+	return out, err
+}
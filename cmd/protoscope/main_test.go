@@ -0,0 +1,163 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the test binary as protoscope itself when asked to, since
+// Main reads its flags from the command line.
+func TestMain(m *testing.M) {
+	if os.Getenv("PROTOSCOPE_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestMainFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "assemble",
+			args:  []string{"-s"},
+			stdin: "1: 2",
+			want:  "\x08\x02",
+		},
+		{
+			name:  "hex output",
+			args:  []string{"-s", "-hex"},
+			stdin: "1: 2 3: {4: 5}",
+			want:  "08021a022005\n",
+		},
+		{
+			name:  "base64 output",
+			args:  []string{"-s", "-base64"},
+			stdin: "1: 2",
+			want:  "CAI=\n",
+		},
+		{
+			name:  "disassemble",
+			stdin: "\x08\x02",
+			want:  "1: 2\n",
+		},
+		{
+			name:  "hex input",
+			args:  []string{"-hex"},
+			stdin: "08 02\n1a02 2005\n",
+			want:  "1: 2\n3: {4: 5}\n",
+		},
+		{
+			name:  "base64 input",
+			args:  []string{"-base64"},
+			stdin: "CAI=\n",
+			want:  "1: 2\n",
+		},
+		{
+			name:  "c literal",
+			args:  []string{"-s", "-format", "c", "-c-name", "msg"},
+			stdin: "1: 2",
+			want:  "const unsigned char msg[] = {0x08, 0x02};\n",
+		},
+		{
+			name:  "go literal",
+			args:  []string{"-s", "-format", "go"},
+			stdin: "1: 2",
+			want:  "[]byte(\"\\x08\\x02\")\n",
+		},
+		{
+			name:    "unknown literal",
+			args:    []string{"-s", "-format", "cobol"},
+			stdin:   "1: 2",
+			wantErr: "unknown -format",
+		},
+		{
+			name:    "literal name without c",
+			args:    []string{"-s", "-format", "go", "-c-name", "msg"},
+			stdin:   "1: 2",
+			wantErr: "-c-name requires -format c",
+		},
+		{
+			name:  "json diagnostics",
+			args:  []string{"-check", "-json"},
+			stdin: "1: 2",
+			want:  "[]\n",
+		},
+		{
+			name:    "json diagnostics with errors",
+			args:    []string{"-check", "-json"},
+			stdin:   "1: {",
+			want:    `[{"file":"","line":1,"col":4,"endLine":1,"endCol":4,"message":"unmatched '{'","code":"syntax"}]` + "\n",
+			wantErr: "the input has errors",
+		},
+		{
+			name:    "bad hex input",
+			args:    []string{"-hex"},
+			stdin:   "0g",
+			wantErr: "invalid hex input",
+		},
+		{
+			name:    "hex with -fmt",
+			args:    []string{"-fmt", "-hex"},
+			stdin:   "1: 2",
+			wantErr: "-fmt cannot be mixed with -hex or -base64",
+		},
+		{
+			name:    "both encodings",
+			args:    []string{"-hex", "-base64"},
+			wantErr: "-hex cannot be mixed with -base64",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], tt.args...)
+			cmd.Env = append(os.Environ(), "PROTOSCOPE_TEST_MAIN=1")
+			cmd.Stdin = strings.NewReader(tt.stdin)
+			var stderr strings.Builder
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("got output %q, want error containing %q", out, tt.wantErr)
+				}
+				if !strings.Contains(stderr.String(), tt.wantErr) {
+					t.Errorf("got error %q, want error containing %q", stderr.String(), tt.wantErr)
+				}
+				// Some errors are reported on stdout, too.
+				if string(out) != tt.want {
+					t.Errorf("got output %q, want %q", out, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v\n%s", err, stderr.String())
+			}
+			if string(out) != tt.want {
+				t.Errorf("got output %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
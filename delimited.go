@@ -0,0 +1,61 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import "github.com/protocolbuffers/protoscope/internal/print"
+
+// writeDelimited prints src as a sequence of varint-length-prefixed messages,
+// each of which is printed as a bare {} block.
+//
+// Returns whatever is left over after the last complete record.
+func (w *writer) writeDelimited(src []byte) []byte {
+	for record := 0; len(src) > 0; record++ {
+		rest, n, extra, ok := decodeVarint(src)
+		if !ok || uint64(len(rest)) < n {
+			break
+		}
+
+		if record > 0 {
+			// Leave a blank line between records.
+			w.NewLine()
+		}
+		w.NewLine()
+		if extra > 0 {
+			w.noteIssue(src, "non-minimal length prefix (%d extra bytes)", extra)
+			w.longForm(extra, " ")
+		}
+		w.Write("{")
+		w.Remarkf("record %d, at %#x", record, w.offset(src))
+		w.StartBlock(print.BlockInfo{
+			HasDelimiters:  true,
+			HeightToFoldAt: 3,
+			UnindentAt:     1,
+		})
+
+		msg := rest[:n]
+		src = rest[n:]
+		w.depth++
+		msg = w.decodeFields(msg)
+		w.depth--
+
+		w.resetRecord()
+
+		w.dumpHexString(msg)
+		w.NewLine()
+		w.Write("}")
+		w.EndBlock()
+	}
+	return src
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDelimitedStream(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{
+			name: "truncated prefix",
+			text: "{1: 2} `80`",
+			want: "{1: 2}  # record 0, at 0x0\n`80`\n",
+		},
+		{
+			name: "non-minimal prefix",
+			text: "long-form:1 {1: 2}",
+			want: "long-form:1 {1: 2}  # record 0, at 0x0\n",
+		},
+		{
+			name: "prefix past the end",
+			text: "{1: 2} `05` 1: 2",
+			want: "{1: 2}  # record 0, at 0x0\n`050802`\n",
+		},
+		{
+			name: "trailing bytes",
+			text: "{1: 2} `0a`",
+			want: "{1: 2}  # record 0, at 0x0\n`0a`\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Write(src, WriterOptions{DelimitedStream: true})
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+
+			// Whatever is not a complete record is still printed, so the
+			// output must reassemble to the input.
+			back, err := NewScanner(got).Exec()
+			if err != nil {
+				t.Fatalf("%s\n%s", err, got)
+			}
+			if !bytes.Equal(back, src) {
+				t.Errorf("reassembled to %x, want %x\n%s", back, src, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import "encoding/json"
+
+// A Diagnostic is a machine-readable description of an error found in a
+// Protoscope file, as produced by ValidateJSON.
+//
+// Lines and columns are one-indexed, matching Position.String.
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	EndLine int    `json:"endLine"`
+	EndCol  int    `json:"endCol"`
+	Message string `json:"message"`
+	// Code classifies the error. Currently, it is always "syntax".
+	Code string `json:"code"`
+}
+
+// Diagnostics executes the Scanner with ExecAll and returns a Diagnostic for
+// each error it encounters. Errors do not currently carry a range, so each
+// Diagnostic ends where it begins.
+func (s *Scanner) Diagnostics() []Diagnostic {
+	_, errs := s.ExecAll()
+
+	var diags []Diagnostic
+	for _, pe := range errs {
+		diags = append(diags, Diagnostic{
+			File:    pe.Pos.File,
+			Line:    pe.Pos.Line + 1,
+			Col:     pe.Pos.Column + 1,
+			EndLine: pe.Pos.Line + 1,
+			EndCol:  pe.Pos.Column + 1,
+			Message: pe.Err.Error(),
+			Code:    "syntax",
+		})
+	}
+	return diags
+}
+
+// ValidateJSON executes the Scanner and returns its Diagnostics as a JSON
+// array, suitable for consumption by an editor. The array is empty if the
+// input is valid.
+func (s *Scanner) ValidateJSON() []byte {
+	diags := s.Diagnostics()
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	out, err := json.Marshal(diags)
+	if err != nil {
+		// Diagnostics contain only strings and ints, so this cannot fail.
+		panic(err)
+	}
+	return out
+}
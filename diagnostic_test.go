@@ -0,0 +1,52 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name, file, text, want string
+	}{
+		{
+			name: "valid",
+			text: "1: {2: 3}",
+			want: `[]`,
+		},
+		{
+			name: "syntax error",
+			file: "foo.txt",
+			text: "1: 2\n  oops",
+			want: `[{"file":"foo.txt","line":2,"col":3,"endLine":2,"endCol":3,"message":"unrecognized symbol \"oops\"","code":"syntax"}]`,
+		},
+		{
+			name: "anonymous input",
+			text: "1: `abc",
+			want: `[{"file":"","line":1,"col":5,"endLine":1,"endCol":5,"message":"unmatched ` + "`" + `","code":"syntax"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner(tt.text)
+			s.SetFile(tt.file)
+			if got := string(s.ValidateJSON()); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
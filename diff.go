@@ -0,0 +1,135 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// A FieldDiff is a field that differs between two messages, as returned by
+// Diff.
+type FieldDiff struct {
+	// Path is the path to the field from the top-level message, such as
+	// "2[1].3[0]": the first field 3 of the second field 2.
+	Path string
+	// Old is the field in the first message, or nil if it was added. New is
+	// the field in the second message, or nil if it was removed.
+	Old, New *Field
+}
+
+// String formats d as a line of a diff: the path, followed by the old and new
+// values in Protoscope syntax. Values that are messages are shown as {...}.
+func (d FieldDiff) String() string {
+	switch {
+	case d.Old == nil:
+		return fmt.Sprintf("+ %s: %s", d.Path, fieldValue(d.New))
+	case d.New == nil:
+		return fmt.Sprintf("- %s: %s", d.Path, fieldValue(d.Old))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", d.Path, fieldValue(d.Old), fieldValue(d.New))
+	}
+}
+
+// Diff parses a and b with Parse, using opts, and returns the fields that
+// differ between them, ignoring the order of fields with different numbers.
+//
+// Fields with the same number are matched up in the order they appear, so
+// that the nth occurrence of a field in a is compared to the nth in b. Fields
+// that are messages in both are compared field by field, rather than being
+// reported as a whole.
+func Diff(a, b []byte, opts WriterOptions) ([]FieldDiff, error) {
+	fa, err := Parse(a, opts)
+	if err != nil {
+		return nil, fmt.Errorf("first input: %w", err)
+	}
+	fb, err := Parse(b, opts)
+	if err != nil {
+		return nil, fmt.Errorf("second input: %w", err)
+	}
+	return diffFields(nil, "", fa, fb), nil
+}
+
+// diffFields appends the differences between two lists of fields in messages
+// at path to diffs.
+func diffFields(diffs []FieldDiff, path string, a, b []Field) []FieldDiff {
+	byNumber := func(fields []Field) map[int][]*Field {
+		m := make(map[int][]*Field)
+		for i := range fields {
+			m[fields[i].Number] = append(m[fields[i].Number], &fields[i])
+		}
+		return m
+	}
+	ma, mb := byNumber(a), byNumber(b)
+
+	var numbers []int
+	for n := range ma {
+		numbers = append(numbers, n)
+	}
+	for n := range mb {
+		if _, ok := ma[n]; !ok {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+
+	for _, n := range numbers {
+		fa, fb := ma[n], mb[n]
+		for i := 0; i < len(fa) || i < len(fb); i++ {
+			elem := fmt.Sprintf("%s%d[%d]", path, n, i)
+			switch {
+			case i >= len(fb):
+				diffs = append(diffs, FieldDiff{Path: elem, Old: fa[i]})
+			case i >= len(fa):
+				diffs = append(diffs, FieldDiff{Path: elem, New: fb[i]})
+			case fa[i].Class == ClassMessage && fb[i].Class == ClassMessage && fa[i].WireType == fb[i].WireType:
+				diffs = diffFields(diffs, elem+".", fa[i].Message, fb[i].Message)
+			case !sameField(fa[i], fb[i]):
+				diffs = append(diffs, FieldDiff{Path: elem, Old: fa[i], New: fb[i]})
+			}
+		}
+	}
+	return diffs
+}
+
+// sameField returns whether two fields that are not both messages have the
+// same encoding, other than their tags.
+func sameField(a, b *Field) bool {
+	return a.WireType == b.WireType && a.Class == b.Class && a.Varint == b.Varint &&
+		bytes.Equal(a.Fixed, b.Fixed) && bytes.Equal(a.Bytes, b.Bytes)
+}
+
+// fieldValue formats the value of f in Protoscope syntax.
+func fieldValue(f *Field) string {
+	switch {
+	case f.WireType == 0:
+		return strconv.FormatUint(f.Varint, 10)
+	case len(f.Fixed) == 4:
+		return fmt.Sprintf("%#xi32", binary.LittleEndian.Uint32(f.Fixed))
+	case len(f.Fixed) == 8:
+		return fmt.Sprintf("%#xi64", binary.LittleEndian.Uint64(f.Fixed))
+	case f.Class == ClassMessage && f.WireType == 3:
+		return "!{...}"
+	case f.Class == ClassMessage:
+		return "{...}"
+	case f.Class == ClassString:
+		return "{" + strconv.Quote(string(f.Bytes)) + "}"
+	default:
+		return fmt.Sprintf("{`%x`}", f.Bytes)
+	}
+}
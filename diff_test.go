@@ -0,0 +1,110 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name, a, b string
+		want       []string
+	}{
+		{
+			name: "same",
+			a:    `1: 2 3: {"x"}`,
+			b:    `1: 2 3: {"x"}`,
+		},
+		{
+			name: "reordered",
+			a:    `1: 2 3: {"x"} 4: 5i32`,
+			b:    `4: 5i32 3: {"x"} 1: 2`,
+		},
+		{
+			name: "changed",
+			a:    `1: 2 2: 1.0 3: {"x"} 4: 5i32`,
+			b:    `1: 3 2: 2.0 3: {"y"} 4: 6i32`,
+			want: []string{
+				"~ 1[0]: 2 -> 3",
+				"~ 2[0]: 0x3ff0000000000000i64 -> 0x4000000000000000i64",
+				`~ 3[0]: {"x"} -> {"y"}`,
+				"~ 4[0]: 0x5i32 -> 0x6i32",
+			},
+		},
+		{
+			name: "added and removed",
+			a:    `1: 2 5: 6 5: 7`,
+			b:    "5: 6 8: {`ff00`}",
+			want: []string{
+				"- 1[0]: 2",
+				"- 5[1]: 7",
+				"+ 8[0]: {`ff00`}",
+			},
+		},
+		{
+			name: "nested",
+			a:    `1: {2: {3: 4} 5: 6} 7: !{8: 9}`,
+			b:    `1: {5: 6 2: {3: 5}} 7: !{8: 10}`,
+			want: []string{
+				"~ 1[0].2[0].3[0]: 4 -> 5",
+				"~ 7[0].8[0]: 9 -> 10",
+			},
+		},
+		{
+			name: "message to bytes",
+			a:    `1: {2: 3}`,
+			b:    "1: {`ff`}",
+			want: []string{"~ 1[0]: {...} -> {`ff`}"},
+		},
+		{
+			name: "wire type",
+			a:    `1: 2`,
+			b:    `1: 2i32`,
+			want: []string{"~ 1[0]: 2 -> 0x2i32"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewScanner(tt.a).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := NewScanner(tt.b).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diffs, err := Diff(a, b, WriterOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range diffs {
+				got = append(got, d.String())
+			}
+			if d := cmp.Diff(tt.want, got); d != "" {
+				t.Fatal("diff mismatch (-want, +got):", d)
+			}
+		})
+	}
+
+	if _, err := Diff([]byte{0xff}, nil, WriterOptions{}); err == nil {
+		t.Error("expected an error for an unparseable input")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/protocolbuffers/protoscope/internal/print"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ResolveFocusPath converts a dotted path of field names, such as "a.b.c",
+// into field numbers suitable for WriterOptions.FocusPath, by looking each
+// name up in desc and then in the message type of the previous field.
+//
+// Components of the path may also be field numbers, which are used as-is.
+func ResolveFocusPath(desc protoreflect.MessageDescriptor, path string) ([]int, error) {
+	var numbers []int
+	for _, name := range strings.Split(path, ".") {
+		if n, err := strconv.Atoi(name); err == nil {
+			numbers = append(numbers, n)
+			if desc != nil {
+				if fd := desc.Fields().ByNumber(protowire.Number(n)); fd != nil {
+					desc = fd.Message()
+				} else {
+					desc = nil
+				}
+			}
+			continue
+		}
+
+		if desc == nil {
+			return nil, fmt.Errorf("cannot resolve %q in %q without a message type", name, path)
+		}
+		fd := desc.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("no field named %q in %s", name, desc.FullName())
+		}
+		numbers = append(numbers, int(fd.Number()))
+		desc = fd.Message()
+	}
+	return numbers, nil
+}
+
+// writeFocused prints only the fields of the message in src along path,
+// replacing each run of other fields with a comment.
+func (w *writer) writeFocused(src []byte, path []int) []byte {
+	elided := 0
+	flush := func() {
+		if elided > 0 {
+			w.NewLine()
+			w.Writef("# elided %d fields", elided)
+			elided = 0
+		}
+	}
+	defer flush()
+
+	for len(src) > 0 {
+		number, typ, n := protowire.ConsumeTag(src)
+		if n < 0 {
+			return src
+		}
+		m := protowire.ConsumeFieldValue(number, typ, src[n:])
+		if m < 0 {
+			return src
+		}
+		field, rest := src[:n+m], src[n+m:]
+
+		if int(number) != path[0] {
+			elided++
+			src = rest
+			continue
+		}
+		flush()
+
+		// The last field in the path, as well as anything we cannot descend
+		// into, is printed in full.
+		if len(path) == 1 || typ != protowire.BytesType {
+			for len(field) > 0 {
+				w.NewLine()
+				s, ok := w.decodeField(field)
+				if !ok {
+					w.DiscardLine()
+					break
+				}
+				field = s
+			}
+			for range w.groups {
+				w.resetGroup()
+			}
+			w.groups = nil
+			w.dumpHexString(field)
+			src = rest
+			continue
+		}
+
+		var fd protoreflect.FieldDescriptor
+		if d := w.descs.Peek(); d != nil && *d != nil {
+			fd = (*d).Fields().ByNumber(number)
+		}
+
+		w.NewLine()
+		w.Writef("%d: {", number)
+		if w.PrintFieldNames && fd != nil {
+			w.Remark(fd.Name())
+		}
+		w.StartBlock(print.BlockInfo{
+			HasDelimiters: true,
+			UnindentAt:    1,
+		})
+
+		if fd != nil {
+			w.descs.Push(fd.Message())
+		}
+		w.depth++
+		payload, _ := protowire.ConsumeBytes(src[n:])
+		payload = w.writeFocused(payload, path[1:])
+		w.dumpHexString(payload)
+		w.depth--
+		if fd != nil {
+			w.descs.Pop()
+		}
+
+		w.NewLine()
+		w.Write("}")
+		w.EndBlock()
+		src = rest
+	}
+	return src
+}
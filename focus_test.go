@@ -0,0 +1,79 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestResolveFocusPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		desc    protoreflect.MessageDescriptor
+		path    string
+		want    []int
+		wantErr bool
+	}{
+		{
+			name: "numbers",
+			path: "1.2.3",
+			want: []int{1, 2, 3},
+		},
+		{
+			name: "names",
+			desc: GetDesc("unittest.TestAllTypes"),
+			path: "repeated_nested_message.bb",
+			want: []int{48, 1},
+		},
+		{
+			name: "mixed",
+			desc: GetDesc("unittest.TestAllTypes"),
+			path: "48.bb",
+			want: []int{48, 1},
+		},
+		{
+			name:    "no such field",
+			desc:    GetDesc("unittest.TestAllTypes"),
+			path:    "optional_nested_message.nope",
+			wantErr: true,
+		},
+		{
+			name:    "names without schema",
+			path:    "1.bb",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveFocusPath(tt.desc, tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tt.want, got); d != "" {
+				t.Fatal("path mismatch (-want, +got):", d)
+			}
+		})
+	}
+}
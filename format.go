@@ -0,0 +1,121 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"strings"
+
+	"github.com/protocolbuffers/protoscope/internal/print"
+)
+
+// Format reformats Protoscope source text with canonical spacing and
+// indentation, without changing what it assembles to.
+//
+// Line breaks are preserved, except that runs of blank lines are collapsed
+// into one. Each line is indented by two spaces per enclosing brace, runs of
+// spaces are collapsed into one, and trailing comments on consecutive lines
+// are aligned the same way Write aligns them. Macro definitions and calls are
+// kept as written. Formatting the output of Format again returns it unchanged.
+//
+// If src does not assemble, the error is returned and src is not formatted.
+func Format(src string) (string, error) {
+	if _, err := NewScanner(src).Exec(); err != nil {
+		return "", err
+	}
+
+	s := NewScanner(src)
+	s.KeepComments = true
+	s.raw = true
+
+	// Indentation is written out by hand, rather than with blocks, since a
+	// line may open several braces; Indent only sets how comments align.
+	p := print.Printer{Indent: 2}
+	depth := 0
+	first := true
+	var prev TokenKind
+	prevEnd := 0
+	for {
+		tok, err := s.Next()
+		if err != nil {
+			return "", err
+		}
+		if tok.Kind == TokenEOF {
+			break
+		}
+		gap := src[prevEnd:tok.Pos.Offset]
+		text := strings.TrimRight(src[tok.Pos.Offset:s.end.Offset], " \t\r")
+		prevEnd = s.end.Offset
+
+		if tok.Kind == TokenRightCurly && depth > 0 {
+			depth--
+		}
+
+		if !first {
+			// Commas and semicolons stay with the token before them.
+			p.Write(separators(gap))
+		}
+		switch newlines := strings.Count(gap, "\n"); {
+		case first || newlines > 0:
+			p.NewLine()
+			if !first && newlines > 1 {
+				p.NewLine()
+			}
+			p.Write(strings.Repeat("  ", depth))
+		case tok.Kind == TokenRightCurly || opensBlock(prev):
+			// No space just inside of braces.
+		case gap != "" || opensBlock(tok.Kind):
+			p.Write(" ")
+		}
+		p.Write(text)
+		if tok.Comment != "" {
+			p.Remark(tok.Comment)
+		}
+
+		if opensBlock(tok.Kind) {
+			depth++
+		}
+		prev = tok.Kind
+		first = false
+	}
+	if first {
+		return "", nil
+	}
+	return string(p.Finish()), nil
+}
+
+// opensBlock returns whether a token of the given kind opens a block that the
+// next } closes.
+func opensBlock(kind TokenKind) bool {
+	switch kind {
+	case TokenLeftCurly, TokenGroupCurly, TokenCRC32, TokenLabel:
+		return true
+	}
+	return false
+}
+
+// separators returns the commas and semicolons in the whitespace between two
+// tokens, up to any trailing comment.
+func separators(gap string) string {
+	if i := strings.IndexByte(gap, '#'); i != -1 {
+		gap = gap[:i]
+	}
+	var seps strings.Builder
+	for _, c := range []byte(gap) {
+		if c == ',' || c == ';' {
+			seps.WriteByte(c)
+		}
+	}
+	return seps.String()
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{
+			name: "empty",
+			text: "  \n\n",
+			want: "",
+		},
+		{
+			name: "spacing",
+			text: "1:   2\t3:{ 4 }  5:!{6: 7}",
+			want: "1: 2 3: {4} 5: !{6: 7}\n",
+		},
+		{
+			name: "indentation",
+			text: "1: {\n2: {\n      3: 4\n}\n    }\n",
+			want: "1: {\n  2: {\n    3: 4\n  }\n}\n",
+		},
+		{
+			name: "crc32",
+			text: "1:{crc32{ 2: 3\n4: 5 }}",
+			want: "1: {crc32{2: 3\n    4: 5}}\n",
+		},
+		{
+			name: "labels",
+			text: "len(a)   @a{1: 2\n}",
+			want: "len(a) @a{1: 2\n}\n",
+		},
+		{
+			name: "blank lines",
+			text: "\n\n1: 2\n\n\n\n3: 4\n\n",
+			want: "1: 2\n\n3: 4\n",
+		},
+		{
+			name: "comments",
+			text: "# header   \n1: {# open\n  2: 3#trailing\n}",
+			want: "# header\n1: {    # open\n  2: 3  # trailing\n}\n",
+		},
+		{
+			name: "separators",
+			text: "1: {1 ,2,3}  ;2: 4 ,5 # five\n6",
+			want: "1: {1, 2, 3}; 2: 4, 5   # five\n6\n",
+		},
+		{
+			name: "separator before comment",
+			text: "1: {\n  2 , # two\n  3\n}",
+			want: "1: {\n  2,  # two\n  3\n}\n",
+		},
+		{
+			name: "directives",
+			text: "long-form-default:1  1: long-form:2  {}\nlong-form-default:0",
+			want: "long-form-default:1 1: long-form:2 {}\nlong-form-default:0\n",
+		},
+		{
+			name: "block comments",
+			text: "1:  2/*  a { */3 /* b\n  c */  4",
+			want: "1: 2/*  a { */3 /* b\n  c */ 4\n",
+		},
+		{
+			name: "literals",
+			text: "1: {  \"a  b\"`00ff`}",
+			want: "1: {\"a  b\"`00ff`}\n",
+		},
+		{
+			name: "char literals",
+			text: "1: {  ' '  '\\''}",
+			want: "1: {' ' '\\''}\n",
+		},
+		{
+			name: "macros",
+			text: "def f(x) = 1: x  \nf({ 2: 3 })   f(4)",
+			want: "def f(x) = 1: x\nf({ 2: 3 }) f(4)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			checkFormat(t, tt.text, got)
+		})
+	}
+}
+
+// notProtoscope is the goldens that are not meant to be valid Protoscope, such
+// as those indented with something other than whitespace.
+var notProtoscope = map[string]bool{
+	"testdata/groups-indent-string.pb.golden": true,
+	"testdata/groups-indent-wide.pb.golden":   true,
+}
+
+func TestFormatFiles(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = append(paths, "language.txt")
+
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Format(string(text))
+			if notProtoscope[path] {
+				if err == nil {
+					t.Fatal("expected an error, since this is not valid Protoscope")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFormat(t, string(text), got)
+		})
+	}
+}
+
+// checkFormat checks that formatted assembles to the same bytes as text, and
+// that it is a fixed point of Format.
+func checkFormat(t *testing.T, text, formatted string) {
+	t.Helper()
+
+	want, err := NewScanner(text).Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewScanner(formatted).Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("formatting changed the assembled bytes: got %x, want %x", got, want)
+	}
+
+	again, err := Format(formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != formatted {
+		t.Errorf("Format is not idempotent: got %q, want %q", again, formatted)
+	}
+}
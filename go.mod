@@ -1,8 +1,11 @@
 module github.com/protocolbuffers/protoscope
 
-go 1.18
+go 1.21
 
 require (
-	github.com/google/go-cmp		v0.5.8
-	google.golang.org/protobuf	v1.28.0
-)
\ No newline at end of file
+	github.com/bufbuild/protocompile v0.14.1
+	github.com/google/go-cmp v0.6.0
+	google.golang.org/protobuf v1.34.2
+)
+
+require golang.org/x/sync v0.8.0 // indirect
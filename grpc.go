@@ -0,0 +1,61 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import "encoding/binary"
+
+// grpcHeaderLen is the length of the header gRPC puts before each message: a
+// compression flag byte followed by a big-endian uint32 length.
+const grpcHeaderLen = 5
+
+// writeGRPCFrames prints src as a sequence of gRPC frames, each of which has
+// its header printed as hex and its payload disassembled as a message.
+//
+// Returns whatever is left over after the last complete frame.
+func (w *writer) writeGRPCFrames(src []byte) []byte {
+	for frame := 0; len(src) >= grpcHeaderLen; frame++ {
+		flag := src[0]
+		n := binary.BigEndian.Uint32(src[1:])
+		if uint64(len(src)-grpcHeaderLen) < uint64(n) {
+			break
+		}
+
+		w.NewLine()
+		w.Writef("`%02x` `%08x`", flag, n)
+		switch flag {
+		case 0:
+			w.Remarkf("frame %d: %d bytes", frame, n)
+		case 1:
+			w.Remarkf("frame %d: %d bytes, compressed", frame, n)
+		default:
+			w.Remarkf("frame %d: %d bytes, unknown flag", frame, n)
+		}
+
+		payload := src[grpcHeaderLen : grpcHeaderLen+n]
+		src = src[grpcHeaderLen+n:]
+		if flag != 0 {
+			// There is no telling what a compressed payload looks like.
+			w.dumpHexString(payload)
+			continue
+		}
+
+		payload = w.decodeFields(payload)
+
+		w.resetRecord()
+
+		w.dumpHexString(payload)
+	}
+	return src
+}
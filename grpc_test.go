@@ -0,0 +1,52 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGRPCFramingRoundTrip(t *testing.T) {
+	tests := []struct {
+		name, text string
+	}{
+		{"empty", ""},
+		{"one frame", "`00` `00000002` 1: 2"},
+		{"two frames", "`00` `00000002` 1: 2 `00` `00000003` 2: {`aa`}"},
+		{"bad payload", "`00` `00000002` `ffff`"},
+		{"short header", "`00` `0000`"},
+		{"short payload", "`00` `00000005` 1: 2"},
+		{"open group", "`00` `00000001` 1:SGROUP `00` `00000001` 1:EGROUP"},
+		{"compressed", "`01` `00000002` 1: 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+			text := Write(want, WriterOptions{GRPCFraming: true})
+			got, err := NewScanner(text).Exec()
+			if err != nil {
+				t.Fatalf("%s\n%s", err, text)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %x, want %x\n%s", got, want, text)
+			}
+		})
+	}
+}
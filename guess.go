@@ -0,0 +1,121 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// A TypeGuess is a message type that some input might be, as returned by
+// GuessMessageType.
+type TypeGuess struct {
+	Type protoreflect.MessageDescriptor
+	// Known is the number of fields, at any depth, that the type describes
+	// with a matching wire type, and Unknown is the number that it does not.
+	// The contents of unknown fields are not counted.
+	Known, Unknown int
+}
+
+// GuessMessageType disassembles src as each message type in files, other than
+// map entries, returning the types it parses as from most to least likely.
+//
+// Types with fewer unknown fields are more likely, and ties are broken in
+// favor of types with more known fields, and then by name.
+func GuessMessageType(src []byte, files *protoregistry.Files) []TypeGuess {
+	var guesses []TypeGuess
+	var try func(msgs protoreflect.MessageDescriptors)
+	try = func(msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < msgs.Len(); i++ {
+			md := msgs.Get(i)
+			try(md.Messages())
+			if md.IsMapEntry() {
+				continue
+			}
+
+			fields, err := Parse(src, WriterOptions{Schema: md})
+			if err != nil {
+				continue
+			}
+			guess := TypeGuess{Type: md}
+			guess.count(fields, md)
+			guesses = append(guesses, guess)
+		}
+	}
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		try(fd.Messages())
+		return true
+	})
+
+	sort.Slice(guesses, func(i, j int) bool {
+		a, b := guesses[i], guesses[j]
+		if a.Unknown != b.Unknown {
+			return a.Unknown < b.Unknown
+		}
+		if a.Known != b.Known {
+			return a.Known > b.Known
+		}
+		return a.Type.FullName() < b.Type.FullName()
+	})
+	return guesses
+}
+
+// count tallies the known and unknown fields among fields, which were parsed
+// as desc.
+func (g *TypeGuess) count(fields []Field, desc protoreflect.MessageDescriptor) {
+	for _, f := range fields {
+		var fd protoreflect.FieldDescriptor
+		if desc != nil {
+			fd = desc.Fields().ByNumber(protowire.Number(f.Number))
+		}
+		if fd == nil || !wireTypeMatches(fd, protowire.Type(f.WireType)) {
+			g.Unknown++
+			continue
+		}
+
+		g.Known++
+		if f.Class == ClassMessage {
+			g.count(f.Message, fd.Message())
+		}
+	}
+}
+
+// wireTypeMatches returns whether a field of type fd may be encoded with
+// wire type typ.
+func wireTypeMatches(fd protoreflect.FieldDescriptor, typ protowire.Type) bool {
+	var want protowire.Type
+	switch fd.Kind() {
+	case protoreflect.BoolKind, protoreflect.EnumKind,
+		protoreflect.Int32Kind, protoreflect.Int64Kind,
+		protoreflect.Uint32Kind, protoreflect.Uint64Kind,
+		protoreflect.Sint32Kind, protoreflect.Sint64Kind:
+		want = protowire.VarintType
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		want = protowire.Fixed32Type
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		want = protowire.Fixed64Type
+	case protoreflect.GroupKind:
+		want = protowire.StartGroupType
+	default:
+		want = protowire.BytesType
+	}
+
+	// Repeated scalars may also be packed.
+	packable := fd.IsList() && want != protowire.BytesType && want != protowire.StartGroupType
+	return typ == want || packable && typ == protowire.BytesType
+}
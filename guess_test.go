@@ -0,0 +1,75 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import "testing"
+
+func TestGuessMessageType(t *testing.T) {
+	tests := []struct {
+		name, text string
+		// want is the full name of the best guess.
+		want string
+		// known and unknown are its field counts.
+		known, unknown int
+	}{
+		{
+			name:  "map fields",
+			text:  `1: {1: {"apple"} 2: 3} 2: {1: 7 2: {1: {"pear"} 2: 5}}`,
+			want:  "mapfields.Inventory",
+			known: 8,
+		},
+		{
+			name:  "scalars",
+			text:  `1: 5 2: 6 11: 1.5i32 12: 2.5 13: true 14: {"hi"}`,
+			want:  "unittest.TestAllTypes",
+			known: 6,
+		},
+		{
+			name:    "groups",
+			text:    `16: !{17: 1} 46: !{47: 2} 46: !{47: 3} 999: 1`,
+			want:    "unittest.TestAllTypes",
+			known:   6,
+			unknown: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+			guesses := GuessMessageType(src, fileset)
+			if len(guesses) == 0 {
+				t.Fatal("no guesses")
+			}
+			got := guesses[0]
+			if string(got.Type.FullName()) != tt.want || got.Known != tt.known || got.Unknown != tt.unknown {
+				t.Errorf("got %s with %d known, %d unknown; want %s with %d known, %d unknown",
+					got.Type.FullName(), got.Known, got.Unknown, tt.want, tt.known, tt.unknown)
+			}
+			for _, g := range guesses {
+				if g.Type.IsMapEntry() {
+					t.Errorf("guessed map entry %s", g.Type.FullName())
+				}
+			}
+		})
+	}
+
+	// Input that is not a message at all can't be any type.
+	if guesses := GuessMessageType([]byte{0xff}, fileset); len(guesses) != 0 {
+		t.Errorf("got %d guesses for garbage, want none", len(guesses))
+	}
+}
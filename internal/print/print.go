@@ -19,6 +19,9 @@ package print
 import (
 	"bytes"
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -84,8 +87,25 @@ type Line struct {
 type Printer struct {
 	// The number of spaces to use per indentation level.
 	Indent int
+	// If not empty, the string to use per indentation level instead of Indent
+	// spaces. Comment alignment uses its DisplayWidth.
+	IndentString string
+	// A string to print at the start of every line, before any indentation.
+	Prefix string
 	// The number of nested folded blocks allowed, < 0 means infinity.
 	MaxFolds int
+	// If set, each folded block counts towards the MaxFolds of the blocks
+	// around it; otherwise, MaxFolds has no effect.
+	CountFolds bool
+	// If set, blocks are never folded onto one line.
+	NoFold bool
+	// If set, remarks are not aligned with those on neighboring lines, and are
+	// instead printed directly after their line's text.
+	NoAlignRemarks bool
+	// If set, remarks are printed in a dim color, using ANSI escape codes.
+	Color bool
+	// If set, lines end in "\r\n" rather than "\n".
+	CRLF bool
 
 	lines  Stack[Line]
 	blocks Stack[BlockInfo]
@@ -96,6 +116,12 @@ func (p *Printer) Current() *Line {
 	return p.Prev(0)
 }
 
+// Depth returns the number of blocks that are currently open, which is the
+// indentation level of lines printed within them.
+func (p *Printer) Depth() int {
+	return len(p.blocks)
+}
+
 // Discards the current line
 func (p *Printer) DiscardLine() {
 	p.lines.Pop()
@@ -120,16 +146,57 @@ func (p *Printer) Prev(n int) *Line {
 	return &p.lines.PeekN(n + 1)[0]
 }
 
+// Grow reserves space for at least n more lines, to avoid repeatedly growing
+// the line buffer when the size of the output can be estimated.
+func (p *Printer) Grow(n int) {
+	if n > cap(p.lines)-len(p.lines) {
+		lines := make(Stack[Line], len(p.lines), len(p.lines)+n)
+		copy(lines, p.lines)
+		p.lines = lines
+	}
+}
+
 // NewLine pushes a new line.
 func (p *Printer) NewLine() {
+	// Reuse the slot of a line that was discarded, if there is one, since
+	// lines are often discarded when disassembly fails partway through. Its
+	// buffer is not reused, since the caller may still hold its bytes.
+	if n := len(p.lines); n < cap(p.lines) {
+		p.lines = p.lines[:n+1]
+		p.lines[n] = Line{}
+		return
+	}
+
+	// Lines are large, so double the buffer when it is full rather than
+	// growing it by smaller steps, as append does once it gets big.
+	p.Grow(len(p.lines) + 16)
 	p.lines.Push(Line{})
 }
 
 // Writes to the current line's buffer with Fprint.
 func (p *Printer) Write(args ...any) {
+	// Most writes are of a single string, which need not go through fmt.
+	if len(args) == 1 {
+		if s, ok := args[0].(string); ok {
+			p.Current().WriteString(s)
+			return
+		}
+	}
 	fmt.Fprint(p.Current(), args...)
 }
 
+// Writes the decimal form of n to the current line's buffer.
+func (p *Printer) WriteUint(n uint64) {
+	var buf [20]byte
+	p.Current().Write(strconv.AppendUint(buf[:0], n, 10))
+}
+
+// Writes the decimal form of n to the current line's buffer.
+func (p *Printer) WriteInt(n int64) {
+	var buf [20]byte
+	p.Current().Write(strconv.AppendInt(buf[:0], n, 10))
+}
+
 // Writes to the current line's buffer with Fprintf.
 func (p *Printer) Writef(f string, args ...any) {
 	fmt.Fprintf(p.Current(), f, args...)
@@ -149,16 +216,28 @@ func (p *Printer) Remarkf(f string, args ...any) {
 
 // Finish dumps the entire contents of the Printer into a byte array.
 func (p *Printer) Finish() []byte {
+	return p.AppendFinish(nil)
+}
+
+// AppendFinish is like Finish, but appends the contents of the Printer to dst
+// and returns the extended buffer.
+func (p *Printer) AppendFinish(dst []byte) []byte {
 	if len(p.blocks) != 0 {
 		panic("called Finish() without closing all blocks")
 	}
 
-	var out bytes.Buffer
+	unit := strings.Repeat(" ", p.Indent)
+	if p.IndentString != "" {
+		unit = p.IndentString
+	}
+	width := DisplayWidth(unit)
+
+	out := bytes.NewBuffer(dst)
 	indent := 0
 	commentCol := -1
 	commentColUntil := -1
 	for i, line := range p.lines {
-		if len(line.remarks) != 0 && commentColUntil < i {
+		if len(line.remarks) != 0 && commentColUntil < i && !p.NoAlignRemarks {
 			// Comments are aligned to the same column if they are contiguous, unless
 			// crossing an indentation boundary would cause the remark column to be
 			// further than it would have been without crossing the boundary.
@@ -166,13 +245,14 @@ func (p *Printer) Finish() []byte {
 			// This allows the column finding algorithm to be linear.
 			indent2 := indent
 			commentCol = -1
+			commentColUntil = len(p.lines)
 			for j, line := range p.lines[i:] {
 				if len(line.remarks) == 0 {
 					commentColUntil = j + i
 					break
 				}
 
-				lineLen := indent2*p.Indent + utf8.RuneCount(line.Bytes())
+				lineLen := indent2*width + visibleWidth(line.Bytes())
 				indent2 += line.indent
 				if lineLen > commentCol {
 					if j > 1 && line.indent != 0 {
@@ -182,22 +262,31 @@ func (p *Printer) Finish() []byte {
 					commentCol = lineLen
 				}
 			}
-			if extra := commentCol % p.Indent; extra != 0 {
-				commentCol += p.Indent - extra
+			if width != 0 {
+				if extra := commentCol % width; extra != 0 {
+					commentCol += width - extra
+				}
 			}
 		}
 
-		for i := 0; i < indent*p.Indent; i++ {
-			out.WriteString(" ")
+		out.WriteString(p.Prefix)
+		for i := 0; i < indent; i++ {
+			out.WriteString(unit)
 		}
 
 		out.Write(line.Bytes())
 		if len(line.remarks) > 0 {
-			needed := commentCol - indent*p.Indent - line.Len()
+			needed := commentCol - indent*width - visibleWidth(line.Bytes())
+			if p.NoAlignRemarks {
+				needed = 0
+			}
 			for i := 0; i < needed; i++ {
 				out.WriteString(" ")
 			}
 
+			if p.Color {
+				out.WriteString(colorRemark)
+			}
 			out.WriteString("  # ")
 			for i, remark := range line.remarks {
 				if i != 0 {
@@ -205,15 +294,89 @@ func (p *Printer) Finish() []byte {
 				}
 				out.WriteString(remark)
 			}
+			if p.Color {
+				out.WriteString(colorReset)
+			}
 		}
 
 		indent += line.indent
+		if p.CRLF {
+			out.WriteString("\r")
+		}
 		out.WriteString("\n")
 	}
 
 	return out.Bytes()
 }
 
+// ANSI escape codes used when Color is set.
+const (
+	colorRemark = "\x1b[90m"
+	colorReset  = "\x1b[0m"
+)
+
+// visibleWidth returns the number of columns b takes up on a terminal, which
+// is its length in runes, less any ANSI escape codes.
+func visibleWidth(b []byte) int {
+	n := 0
+	for i := 0; i < len(b); {
+		if b[i] == '\x1b' && i+1 < len(b) && b[i+1] == '[' {
+			// Skip to the end of the escape sequence, which is a letter.
+			for i += 2; i < len(b) && !('a' <= b[i] && b[i] <= 'z' || 'A' <= b[i] && b[i] <= 'Z'); i++ {
+			}
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRune(b[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+// DisplayWidth returns the number of columns s takes up on a terminal, where
+// wide East Asian characters take up two columns, combining marks and other
+// invisible characters take up none, and tabs advance to the next multiple of
+// eight.
+func DisplayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			n += 8 - n%8
+		case r == '\u200d' || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		case isWide(r):
+			n += 2
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// wideRanges are the blocks of characters that terminals draw two columns
+// wide, from the Wide and Fullwidth classes of Unicode's East Asian Width.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115f}, {0x2e80, 0x303e}, {0x3041, 0x33ff}, {0x3400, 0x4dbf},
+	{0x4e00, 0x9fff}, {0xa000, 0xa4cf}, {0xa960, 0xa97f}, {0xac00, 0xd7a3},
+	{0xf900, 0xfaff}, {0xfe10, 0xfe19}, {0xfe30, 0xfe6f}, {0xff00, 0xff60},
+	{0xffe0, 0xffe6}, {0x1f300, 0x1f64f}, {0x1f900, 0x1f9ff},
+	{0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+}
+
+// isWide returns whether r is drawn two columns wide.
+func isWide(r rune) bool {
+	for _, wr := range wideRanges {
+		if r < wr[0] {
+			return false
+		}
+		if r <= wr[1] {
+			return true
+		}
+	}
+	return false
+}
+
 type BlockInfo struct {
 	// Whether this block will start and end with delimiters that do not need to
 	// have spaces placed before/after them, allowing for output like {x} instead
@@ -269,7 +432,7 @@ func (p *Printer) EndBlock() *Line {
 	}()
 
 	// Decide whether to fold this block.
-	if height > bi.HeightToFoldAt || height < 2 {
+	if p.NoFold || height > bi.HeightToFoldAt || height < 2 {
 		return start
 	}
 
@@ -282,7 +445,7 @@ func (p *Printer) EndBlock() *Line {
 		}
 	}
 
-	if folds > p.MaxFolds {
+	if p.MaxFolds >= 0 && folds > p.MaxFolds {
 		return start
 	}
 
@@ -304,10 +467,30 @@ func (p *Printer) EndBlock() *Line {
 	}
 
 	start.folds = folds
+	if p.CountFolds {
+		start.folds++
+	}
 	p.lines = p.lines[:bi.start+1]
 	return start
 }
 
+// Folds the last count lines into as many columns as fit within width, given
+// the widest of those lines.
+func (p *Printer) FoldIntoWidth(width, count int) {
+	widest := 0
+	for _, line := range p.lines.PeekN(count) {
+		if n := utf8.RuneCount(line.Bytes()); n > widest {
+			widest = n
+		}
+	}
+
+	cols := (width + 1) / (widest + 1)
+	if cols < 1 {
+		cols = 1
+	}
+	p.FoldIntoColumns(cols, count)
+}
+
 // Folds the last count lines into lines with `cols` columns each.
 func (p *Printer) FoldIntoColumns(cols, count int) {
 	toFold := p.lines.PopN(count)
@@ -0,0 +1,43 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package print
+
+import "testing"
+
+func TestNewLineKeepsDiscardedBytes(t *testing.T) {
+	var p Printer
+	p.NewLine()
+	p.Write("kept")
+	held := p.Current().Bytes()
+	p.DiscardLine()
+
+	// The discarded line's slot is reused, but the bytes held from it must
+	// not be overwritten.
+	p.NewLine()
+	p.Write("overwritten?")
+	if string(held) != "kept" {
+		t.Errorf("held bytes changed to %q", held)
+	}
+
+	p.Reset(p.Mark() - 1)
+	p.NewLine()
+	p.Write("again")
+	if string(held) != "kept" {
+		t.Errorf("held bytes changed to %q after Reset", held)
+	}
+	if got := string(p.Finish()); got != "again\n" {
+		t.Errorf("got output %q, want %q", got, "again\n")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EncodeLiteral formats data as a byte string or array literal in lang, which
+// is one of "c", "go", "python", or "rust", for pasting into source files.
+// Printable ASCII is kept as-is, and all other bytes are escaped.
+//
+// For example, the bytes 08 07 are formatted as []byte("\x08\x07") in Go, and
+// b"\x08\x07" in Python and Rust. C uses CArray.
+func EncodeLiteral(data []byte, lang string) (string, error) {
+	switch lang {
+	case "c":
+		return CArray(data, ""), nil
+	case "go":
+		return "[]byte(" + quoteBytes(data) + ")", nil
+	case "python", "rust":
+		return "b" + quoteBytes(data), nil
+	default:
+		return "", fmt.Errorf("unknown literal language: %q", lang)
+	}
+}
+
+// quoteBytes quotes data as a string literal using only \x escapes, which
+// Go, Python, and Rust all accept with the same meaning.
+func quoteBytes(data []byte) string {
+	var b strings.Builder
+	b.WriteString(`"`)
+	for _, c := range data {
+		if c >= 0x20 && c < 0x7f && c != '"' && c != '\\' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "\\x%02x", c)
+		}
+	}
+	b.WriteString(`"`)
+	return b.String()
+}
+
+// cArrayLineWidth is the number of bytes CArray puts on each line.
+const cArrayLineWidth = 12
+
+// CArray formats data as a C array initializer, such as {0x08, 0x07}, for
+// embedding in C or C++ source. Arrays of more than a dozen bytes are split
+// across several lines.
+//
+// If name is not empty, the initializer is part of a declaration of a const
+// unsigned char array with that name.
+func CArray(data []byte, name string) string {
+	var b strings.Builder
+	if name != "" {
+		fmt.Fprintf(&b, "const unsigned char %s[] = ", name)
+	}
+
+	b.WriteString("{")
+	multiline := len(data) > cArrayLineWidth
+	for i, c := range data {
+		switch {
+		case multiline && i%cArrayLineWidth == 0:
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n  ")
+		case i > 0:
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%#02x", c)
+	}
+	if multiline {
+		b.WriteString(",\n")
+	}
+	b.WriteString("}")
+
+	if name != "" {
+		b.WriteString(";")
+	}
+	return b.String()
+}
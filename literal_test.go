@@ -0,0 +1,82 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import "testing"
+
+func TestCArray(t *testing.T) {
+	tests := []struct {
+		name, text, array string
+		want              string
+	}{
+		{"empty", "", "", "{}"},
+		{"short", "1: 7", "", "{0x08, 0x07}"},
+		{"named", "1: 7", "msg", "const unsigned char msg[] = {0x08, 0x07};"},
+		{
+			name: "long",
+			text: `1: {"hello, world"}`,
+			want: "{\n  0x0a, 0x0c, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x2c, 0x20, 0x77, 0x6f, 0x72,\n  0x6c, 0x64,\n}",
+		},
+		{
+			name:  "long named",
+			text:  `1: {"hello, world!"}`,
+			array: "msg",
+			want:  "const unsigned char msg[] = {\n  0x0a, 0x0d, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x2c, 0x20, 0x77, 0x6f, 0x72,\n  0x6c, 0x64, 0x21,\n};",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Assemble(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := CArray(data, tt.array); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeLiteral(t *testing.T) {
+	data, err := Assemble(`1: 7 2: {"a\"b\\c"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		lang, want string
+	}{
+		{"c", "{0x08, 0x07, 0x12, 0x05, 0x61, 0x22, 0x62, 0x5c, 0x63}"},
+		{"go", `[]byte("\x08\x07\x12\x05a\x22b\x5cc")`},
+		{"python", `b"\x08\x07\x12\x05a\x22b\x5cc"`},
+		{"rust", `b"\x08\x07\x12\x05a\x22b\x5cc"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			got, err := EncodeLiteral(data, tt.lang)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := EncodeLiteral(data, "cobol"); err == nil {
+		t.Error("unknown language did not fail")
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A macro is a parameterized sequence of tokens defined with def.
+type macro struct {
+	name   string
+	params []string
+	body   string
+	// pos is the position of the def that introduced the macro.
+	pos Position
+}
+
+// An expansion is a macro expansion that the Scanner is currently reading
+// from instead of from the input proper.
+type expansion struct {
+	macro *macro
+	// call is the position of the outermost call that led to this expansion.
+	call Position
+
+	// The input and position to restore once the expansion is exhausted.
+	input string
+	pos   Position
+}
+
+// isIdent returns whether c can appear in a macro or parameter name.
+func isIdent(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// skipSpaces advances past spaces and tabs, but not newlines.
+func (s *Scanner) skipSpaces() {
+	for !s.isEOF(0) && (s.Input[s.pos.Offset] == ' ' || s.Input[s.pos.Offset] == '\t') {
+		s.advance(1)
+	}
+}
+
+// consumeIdent consumes a name made up of isIdent bytes, which must not begin
+// with a digit.
+func (s *Scanner) consumeIdent() (string, error) {
+	start := s.pos
+	for !s.isEOF(0) && isIdent(s.Input[s.pos.Offset]) {
+		s.advance(1)
+	}
+	name := s.Input[start.Offset:s.pos.Offset]
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return "", &ParseError{start, errors.New("expected a name")}
+	}
+	return name, nil
+}
+
+// expect consumes the byte c, returning an error if it is not next.
+func (s *Scanner) expect(c byte) error {
+	if s.isEOF(0) || s.Input[s.pos.Offset] != c {
+		return &ParseError{s.pos, fmt.Errorf("expected '%c'", c)}
+	}
+	s.advance(1)
+	return nil
+}
+
+// skipLiteral advances past a quoted string, character literal, hex literal,
+// or block comment if the cursor is on one; unterminated literals run to the
+// end of the input.
+func (s *Scanner) skipLiteral() bool {
+	switch s.Input[s.pos.Offset] {
+	case '/':
+		if !s.isBlockComment() {
+			return false
+		}
+		s.advance(2)
+		if i := s.index("*/"); i != -1 {
+			s.advance(i + 2)
+		} else {
+			s.advance(len(s.Input))
+		}
+		return true
+	case '"':
+		s.advance(1)
+		for !s.isEOF(0) && s.Input[s.pos.Offset] != '"' {
+			if s.Input[s.pos.Offset] == '\\' {
+				s.advance(1)
+			}
+			s.advance(1)
+		}
+		s.advance(1)
+		return true
+	case '\'':
+		s.advance(1)
+		if !s.isEOF(0) && s.Input[s.pos.Offset] == '\\' {
+			s.advance(1)
+		}
+		s.advance(1)
+		s.consumeUntil('\'')
+		return true
+	case '`':
+		s.advance(1)
+		s.consumeUntil('`')
+		return true
+	}
+	return false
+}
+
+// parseDef parses a macro definition, assuming that the cursor is just past
+// the def keyword at start:
+//
+//	def name(param1, param2) = tokens...
+//
+// The body of the macro runs until the end of the line, or until any braces
+// opened on that line have been closed, whichever is later.
+func (s *Scanner) parseDef(start Position) error {
+	s.skipSpaces()
+	name, err := s.consumeIdent()
+	if err != nil {
+		return err
+	}
+	if m, ok := s.macros[name]; ok {
+		return &ParseError{start, fmt.Errorf("macro %q is already defined at %s", name, m.pos)}
+	}
+
+	m := &macro{name: name, pos: start}
+	if err := s.expect('('); err != nil {
+		return err
+	}
+	for {
+		s.skipSpaces()
+		if !s.isEOF(0) && s.Input[s.pos.Offset] == ')' && len(m.params) == 0 {
+			break
+		}
+		param, err := s.consumeIdent()
+		if err != nil {
+			return err
+		}
+		m.params = append(m.params, param)
+		s.skipSpaces()
+		if s.isEOF(0) || s.Input[s.pos.Offset] != ',' {
+			break
+		}
+		s.advance(1)
+	}
+	if err := s.expect(')'); err != nil {
+		return err
+	}
+	s.skipSpaces()
+	if err := s.expect('='); err != nil {
+		return err
+	}
+
+	bodyStart := s.pos.Offset
+	depth := 0
+	for !s.isEOF(0) {
+		if s.skipLiteral() {
+			continue
+		}
+		c := s.Input[s.pos.Offset]
+		if c == '\n' && depth <= 0 {
+			break
+		}
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '#':
+			// Comments are skipped so that braces in them are not counted.
+			for !s.isEOF(0) && s.Input[s.pos.Offset] != '\n' {
+				s.advance(1)
+			}
+			continue
+		}
+		s.advance(1)
+	}
+	m.body = s.Input[bodyStart:s.pos.Offset]
+
+	if s.macros == nil {
+		s.macros = make(map[string]*macro)
+	}
+	s.macros[name] = m
+	return nil
+}
+
+// parseCall parses the arguments to a call of m, assuming that the cursor is
+// just past the opening parenthesis. Arguments are separated by commas, and
+// may contain any tokens, including nested parentheses and braces.
+func (s *Scanner) parseCall(m *macro, start Position) ([]string, error) {
+	var args []string
+	argStart := s.pos.Offset
+	depth := 0
+	for {
+		if s.isEOF(0) {
+			return nil, &ParseError{start, fmt.Errorf("unterminated call to macro %q", m.name)}
+		}
+		if s.skipLiteral() {
+			continue
+		}
+
+		c := s.Input[s.pos.Offset]
+		if depth == 0 && (c == ',' || c == ')') {
+			args = append(args, strings.TrimSpace(s.Input[argStart:s.pos.Offset]))
+			s.advance(1)
+			if c == ')' {
+				break
+			}
+			argStart = s.pos.Offset
+			continue
+		}
+
+		switch c {
+		case '(', '{':
+			depth++
+		case ')', '}':
+			depth--
+		}
+		s.advance(1)
+	}
+
+	if len(m.params) == 0 && len(args) == 1 && args[0] == "" {
+		args = nil
+	}
+	if len(args) != len(m.params) {
+		return nil, &ParseError{start, fmt.Errorf("macro %q (defined at %s) takes %d arguments, got %d", m.name, m.pos, len(m.params), len(args))}
+	}
+	return args, nil
+}
+
+// expand begins reading tokens from the expansion of a call to m.
+func (s *Scanner) expand(m *macro, args []string, call Position) error {
+	for _, e := range s.expansions {
+		if e.macro == m {
+			return &ParseError{call, fmt.Errorf("recursive expansion of macro %q (defined at %s)", m.name, m.pos)}
+		}
+	}
+	if len(s.expansions) != 0 {
+		call = s.expansions[0].call
+	}
+
+	// Substitute the arguments textually, skipping over the insides of literals
+	// and comments.
+	var text strings.Builder
+	body := NewScanner(m.body)
+	for !body.isEOF(0) {
+		start := body.pos.Offset
+		if body.skipLiteral() {
+			text.WriteString(body.Input[start:body.pos.Offset])
+			continue
+		}
+
+		c := body.Input[body.pos.Offset]
+		if c == '#' {
+			for !body.isEOF(0) && body.Input[body.pos.Offset] != '\n' {
+				body.advance(1)
+			}
+			text.WriteString(body.Input[start:body.pos.Offset])
+			continue
+		}
+		if !isIdent(c) {
+			body.advance(1)
+			text.WriteByte(c)
+			continue
+		}
+
+		for !body.isEOF(0) && isIdent(body.Input[body.pos.Offset]) {
+			body.advance(1)
+		}
+		word := body.Input[start:body.pos.Offset]
+		for i, param := range m.params {
+			if word == param {
+				word = args[i]
+				break
+			}
+		}
+		text.WriteString(word)
+	}
+
+	s.expansions = append(s.expansions, expansion{
+		macro: m,
+		call:  call,
+		input: s.Input,
+		pos:   s.pos,
+	})
+	s.Input = text.String()
+	s.pos = Position{File: s.pos.File}
+	return nil
+}
+
+// popExpansion resumes reading from wherever the innermost expansion was
+// called.
+func (s *Scanner) popExpansion() {
+	e := s.expansions[len(s.expansions)-1]
+	s.expansions = s.expansions[:len(s.expansions)-1]
+	s.Input = e.input
+	s.pos = e.pos
+}
+
+// inExpansion converts an error that occurred while reading from an expansion
+// into one that points at the call site.
+func (s *Scanner) inExpansion(err error) error {
+	e := s.expansions[len(s.expansions)-1]
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return &ParseError{e.call, fmt.Errorf("in expansion of macro %q (defined at %s): %w", e.macro.name, e.macro.pos, err)}
+}
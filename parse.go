@@ -0,0 +1,176 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/protocolbuffers/protoscope/wire"
+)
+
+// A Field is a single field of a message, as returned by Parse.
+type Field struct {
+	Number int
+	// WireType is the wire type from the field's tag, one of the
+	// protowire.Type constants.
+	WireType int
+	// Class is how the field's contents were interpreted, the same way Write
+	// would print them.
+	Class FieldClass
+	// Offset is the offset of this field's tag from the start of the input.
+	Offset int
+
+	// Varint is the value of a VARINT field.
+	Varint uint64
+	// Fixed is the little-endian contents of an I32 or I64 field.
+	Fixed []byte
+	// Bytes is the contents of a LEN field, even if it was interpreted as a
+	// message.
+	Bytes []byte
+	// Message is the fields of a LEN field interpreted as a message, or of a
+	// group.
+	Message []Field
+}
+
+// Parse decodes src into a tree of fields. Which length-prefixed fields are
+// messages, strings, packed fields, or bytes is decided by Write itself, so
+// each field's Class is exactly what Write would print it as.
+//
+// Of opts, those that only change how Write formats its output are ignored,
+// as are SortFields, FocusPath, GRPCFraming, and DelimitedStream.
+//
+// If src is not a valid message, Parse returns the fields before the first
+// one that could not be parsed, along with an error.
+func Parse(src []byte, opts WriterOptions) ([]Field, error) {
+	opts.SortFields = false
+	opts.FocusPath = nil
+	opts.GRPCFraming = false
+	opts.DelimitedStream = false
+
+	var report Report
+	appendWrite(nil, src, opts, &report)
+
+	p := parser{opts: opts, src: src, classes: make(map[int]FieldClass, len(report.Fields))}
+	for _, f := range report.Fields {
+		p.classes[f.Offset] = f.Class
+	}
+	fields, _, err := p.parseFields(src, -1)
+	return fields, err
+}
+
+type parser struct {
+	opts WriterOptions
+	src  []byte
+	// classes is the class Write chose for the field at each offset.
+	classes map[int]FieldClass
+}
+
+// offset returns the offset of a suffix of p.src.
+func (p *parser) offset(rest []byte) int {
+	return cap(p.src) - cap(rest)
+}
+
+// parseFields parses fields from src until src runs out or, if group is not
+// negative, an EGROUP tag for group is reached, which is left unconsumed.
+func (p *parser) parseFields(src []byte, group int) ([]Field, []byte, error) {
+	var fields []Field
+	for len(src) > 0 {
+		start := src
+		value, _, n, ok := wire.ConsumeVarint(src)
+		if !ok {
+			return fields, src, fmt.Errorf("bad tag at offset %#x", p.offset(start))
+		}
+		number, typ := protowire.DecodeTag(value)
+		if typ == protowire.EndGroupType {
+			if int(number) != group {
+				return fields, src, fmt.Errorf("unexpected end of group at offset %#x", p.offset(start))
+			}
+			return fields, src, nil
+		}
+		class, ok := p.classes[p.offset(start)]
+		if !ok {
+			if number == 0 {
+				return fields, src, fmt.Errorf("field number 0 at offset %#x", p.offset(start))
+			}
+			return fields, src, fmt.Errorf("undecodable field at offset %#x", p.offset(start))
+		}
+		if class == ClassFailed {
+			return fields, src, fmt.Errorf("bad field contents at offset %#x", p.offset(start))
+		}
+		src = src[n:]
+
+		f := Field{
+			Number:   int(number),
+			WireType: int(typ),
+			Class:    class,
+			Offset:   p.offset(start),
+		}
+		switch typ {
+		case protowire.VarintType:
+			f.Varint, _, n, ok = wire.ConsumeVarint(src)
+			if !ok {
+				return fields, start, fmt.Errorf("bad varint at offset %#x", p.offset(src))
+			}
+			src = src[n:]
+
+		case protowire.Fixed32Type, protowire.Fixed64Type:
+			n := 4
+			if typ == protowire.Fixed64Type {
+				n = 8
+			}
+			if len(src) < n {
+				return fields, start, fmt.Errorf("truncated fixed-width field at offset %#x", p.offset(src))
+			}
+			f.Fixed, src = src[:n], src[n:]
+
+		case protowire.BytesType:
+			length, _, n, ok := wire.ConsumeVarint(src)
+			if !ok || uint64(len(src)-n) < length {
+				return fields, start, fmt.Errorf("bad length prefix at offset %#x", p.offset(src))
+			}
+			f.Bytes, src = src[n:n+int(length)], src[n+int(length):]
+			if f.Class == ClassMessage {
+				children, rest, err := p.parseFields(f.Bytes, -1)
+				f.Message = children
+				if err != nil {
+					return append(fields, f), rest, err
+				}
+			}
+
+		case protowire.StartGroupType:
+			if p.opts.NoGroups {
+				return fields, start, fmt.Errorf("group at offset %#x", p.offset(start))
+			}
+			children, rest, err := p.parseFields(src, int(number))
+			f.Message = children
+			if err != nil {
+				return append(fields, f), rest, err
+			}
+			if len(rest) == 0 {
+				return append(fields, f), rest, fmt.Errorf("unterminated group at offset %#x", p.offset(start))
+			}
+			// Skip the EGROUP tag.
+			_, _, n, _ := wire.ConsumeVarint(rest)
+			src = rest[n:]
+
+		default:
+			return fields, start, fmt.Errorf("bad wire type %d at offset %#x", typ, p.offset(start))
+		}
+		fields = append(fields, f)
+	}
+	return fields, src, nil
+}
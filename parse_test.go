@@ -0,0 +1,185 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, text string
+		opts       WriterOptions
+		want       []Field
+		wantErr    bool
+	}{
+		{
+			name: "scalars",
+			text: "1: 5 2: 3i32 3: 4i64",
+			want: []Field{
+				{Number: 1, WireType: 0, Class: ClassVarint, Offset: 0, Varint: 5},
+				{Number: 2, WireType: 5, Class: ClassFixed, Offset: 2, Fixed: []byte{3, 0, 0, 0}},
+				{Number: 3, WireType: 1, Class: ClassFixed, Offset: 7, Fixed: []byte{4, 0, 0, 0, 0, 0, 0, 0}},
+			},
+		},
+		{
+			name: "message",
+			text: `1: {2: 3 4: {"hello"}} 5: {"hello"} 6: {` + "`ff00`" + `}`,
+			want: []Field{
+				{Number: 1, WireType: 2, Class: ClassMessage, Offset: 0, Bytes: []byte("\x10\x03\x22\x05hello"), Message: []Field{
+					{Number: 2, WireType: 0, Class: ClassVarint, Offset: 2, Varint: 3},
+					{Number: 4, WireType: 2, Class: ClassString, Offset: 4, Bytes: []byte("hello")},
+				}},
+				{Number: 5, WireType: 2, Class: ClassString, Offset: 11, Bytes: []byte("hello")},
+				{Number: 6, WireType: 2, Class: ClassBytes, Offset: 18, Bytes: []byte{0xff, 0x00}},
+			},
+		},
+		{
+			name: "group",
+			text: "1: !{2: 3} 4: 5",
+			want: []Field{
+				{Number: 1, WireType: 3, Class: ClassMessage, Offset: 0, Message: []Field{
+					{Number: 2, WireType: 0, Class: ClassVarint, Offset: 1, Varint: 3},
+				}},
+				{Number: 4, WireType: 0, Class: ClassVarint, Offset: 4, Varint: 5},
+			},
+		},
+		{
+			name: "recursion depth",
+			text: `1: {2: {3: 4}}`,
+			opts: WriterOptions{MessageRecursionDepth: 1},
+			want: []Field{
+				{Number: 1, WireType: 2, Class: ClassMessage, Offset: 0, Bytes: []byte{0x12, 0x02, 0x18, 0x04}, Message: []Field{
+					{Number: 2, WireType: 2, Class: ClassBytes, Offset: 2, Bytes: []byte{0x18, 0x04}},
+				}},
+			},
+		},
+		{
+			name: "unterminated group",
+			text: "1: 2 3:SGROUP 4: 5",
+			want: []Field{
+				{Number: 1, WireType: 0, Class: ClassVarint, Offset: 0, Varint: 2},
+				{Number: 3, WireType: 3, Class: ClassMessage, Offset: 2, Message: []Field{
+					{Number: 4, WireType: 0, Class: ClassVarint, Offset: 3, Varint: 5},
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "not a message",
+			text: "1: 2 3: {`05`}",
+			want: []Field{
+				{Number: 1, WireType: 0, Class: ClassVarint, Offset: 0, Varint: 2},
+				{Number: 3, WireType: 2, Class: ClassBytes, Offset: 2, Bytes: []byte{0x05}},
+			},
+		},
+		{
+			name: "bad length",
+			text: "1: 2 3:LEN 5",
+			want: []Field{
+				{Number: 1, WireType: 0, Class: ClassVarint, Offset: 0, Varint: 2},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Parse(src, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if d := cmp.Diff(tt.want, got); d != "" {
+				t.Error("fields mismatch (-want, +got):", d)
+			}
+		})
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	src, err := NewScanner(`1: {1: {"apple"} 2: 3}`).Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(src, WriterOptions{Schema: GetDesc("mapfields.Inventory")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key of the map entry is a string, even though it also parses as a
+	// message.
+	if len(got) != 1 || len(got[0].Message) != 2 {
+		t.Fatalf("unexpected fields: %v", got)
+	}
+	if key := got[0].Message[0]; key.Class != ClassString || string(key.Bytes) != "apple" {
+		t.Errorf("got key %v, want string \"apple\"", key)
+	}
+}
+
+func TestParseGoldens(t *testing.T) {
+	for _, tt := range readGoldens(t) {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.SortFields = false
+			opts.FocusPath = nil
+			opts.GRPCFraming = false
+			opts.DelimitedStream = false
+			_, report := WriteReport(tt.pb, opts)
+
+			want := make(map[int]FieldReport)
+			for _, f := range report.Fields {
+				want[f.Offset] = f
+			}
+
+			// Every field that Parse returns must be interpreted the same way
+			// Write interpreted it.
+			got, err := Parse(tt.pb, tt.opts)
+			seen := make(map[int]bool)
+			var check func([]Field)
+			check = func(fields []Field) {
+				for _, f := range fields {
+					w, ok := want[f.Offset]
+					if !ok {
+						t.Errorf("Parse found field %d at offset %#x; Write did not", f.Number, f.Offset)
+						continue
+					}
+					if uint64(f.Number) != w.Number || f.Class != w.Class {
+						t.Errorf("at offset %#x: Parse found field %d (%v); Write found field %d (%v)",
+							f.Offset, f.Number, f.Class, w.Number, w.Class)
+					}
+					seen[f.Offset] = true
+					check(f.Message)
+				}
+			}
+			check(got)
+			if err != nil {
+				return
+			}
+
+			// If Parse succeeded, it must have found every field Write did.
+			for _, f := range report.Fields {
+				if !seen[f.Offset] {
+					t.Errorf("Write found field %d at offset %#x; Parse did not", f.Number, f.Offset)
+				}
+			}
+		})
+	}
+}
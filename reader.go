@@ -0,0 +1,118 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// readChunk is how much input a reader-backed Scanner reads at a time. It is
+// also how far the cursor may get ahead of the start of Input before the
+// consumed part is thrown away.
+const readChunk = 4096
+
+// NewScannerReader creates a new scanner for parsing input read from r.
+//
+// Rather than reading all of r up front, the Scanner reads it as needed, and
+// discards input it is done with. Tokens which span a lot of input, such as
+// long strings, are still read into memory in their entirety.
+//
+// Errors returned by r other than io.EOF are returned by Exec.
+func NewScannerReader(r io.Reader) *Scanner {
+	s := NewScanner("")
+	s.reader = r
+	return s
+}
+
+// fill reads more input from the Scanner's reader, if it has one, and returns
+// whether there was any more input.
+//
+// Input is only read from the reader while outside of macro expansions, since
+// those replace Input with the text of the expansion.
+func (s *Scanner) fill() bool {
+	if s.reader == nil || len(s.expansions) != 0 {
+		return false
+	}
+
+	buf := make([]byte, readChunk)
+	for {
+		n, err := s.reader.Read(buf)
+		if n > 0 {
+			s.Input += string(buf[:n])
+			return true
+		}
+		if err != nil {
+			if err != io.EOF {
+				s.readErr = err
+			}
+			s.reader = nil
+			return false
+		}
+	}
+}
+
+// compact discards the input before the cursor, once there is enough of it,
+// so that reading a large input does not require holding all of it in memory.
+//
+// This must only be called between tokens, outside of macro expansions.
+func (s *Scanner) compact() {
+	if s.reader == nil || s.pos.Offset < readChunk {
+		return
+	}
+	s.base += s.pos.Offset
+	s.Input = s.Input[s.pos.Offset:]
+	s.pos.Offset = 0
+}
+
+// index returns the index of sub in the input after the cursor, reading more
+// input as needed, or -1 if it does not occur.
+func (s *Scanner) index(sub string) int {
+	for from := s.pos.Offset; ; {
+		if i := strings.Index(s.Input[from:], sub); i != -1 {
+			return from + i - s.pos.Offset
+		}
+		// sub may straddle the boundary with the input we have yet to read.
+		from = len(s.Input) - len(sub) + 1
+		if from < s.pos.Offset {
+			from = s.pos.Offset
+		}
+		if !s.fill() {
+			return -1
+		}
+	}
+}
+
+// absolute converts pos, which is relative to the current Input, to be relative
+// to the start of the input read by the Scanner.
+func (s *Scanner) absolute(pos Position) Position {
+	pos.Offset += s.base
+	return pos
+}
+
+// absoluteToken converts the positions in the results of lexing a token with
+// absolute.
+func (s *Scanner) absoluteToken(tok Token, err error) (Token, error) {
+	if err != nil {
+		var pe *ParseError
+		if errors.As(err, &pe) {
+			pe.Pos = s.absolute(pe.Pos)
+		}
+		return Token{}, err
+	}
+	tok.Pos = s.absolute(tok.Pos)
+	return tok, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// bigInput returns an input that is several times larger than readChunk, with
+// literals and comments that straddle chunk boundaries.
+func bigInput() string {
+	var b strings.Builder
+	b.WriteString("def f(x) = 1: {x}\n")
+	for i := 0; b.Len() < 5*readChunk; i++ {
+		fmt.Fprintf(&b, "%d: {\"%s\"} /* %s */ f(%d) `%s` # %d\n",
+			i%100, strings.Repeat("x", i%300), strings.Repeat("*", i%200), i, strings.Repeat("ab ", i%150), i)
+	}
+	return b.String()
+}
+
+func TestScannerReader(t *testing.T) {
+	readers := map[string]func(string) io.Reader{
+		"strings":  func(s string) io.Reader { return strings.NewReader(s) },
+		"one byte": func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+		"half":     func(s string) io.Reader { return iotest.HalfReader(strings.NewReader(s)) },
+	}
+	inputs := map[string]string{
+		"language.txt": LanguageTxt,
+		"big":          bigInput(),
+		"big error":    bigInput() + "1: {\n  oops\n}",
+	}
+
+	for rname, reader := range readers {
+		for iname, input := range inputs {
+			t.Run(rname+"/"+iname, func(t *testing.T) {
+				want := NewScanner(input)
+				got := NewScannerReader(reader(input))
+				for {
+					wantTok, wantErr := want.Next()
+					gotTok, gotErr := got.Next()
+					if d := cmp.Diff(wantTok, gotTok); d != "" {
+						t.Fatal("token mismatch (-want, +got):", d)
+					}
+					if d := cmp.Diff(fmt.Sprint(wantErr), fmt.Sprint(gotErr)); d != "" {
+						t.Fatal("error mismatch (-want, +got):", d)
+					}
+					var pe *ParseError
+					if errors.As(gotErr, &pe) && pe.Pos.Offset != strings.Index(input, "oops") {
+						t.Fatalf("error at offset %d, want %d", pe.Pos.Offset, strings.Index(input, "oops"))
+					}
+					if wantErr != nil || wantTok.Kind == TokenEOF {
+						break
+					}
+				}
+				if len(got.Input) > 3*readChunk {
+					t.Fatalf("kept %d bytes of input in memory", len(got.Input))
+				}
+			})
+		}
+	}
+}
+
+func TestScannerReaderError(t *testing.T) {
+	errBroken := errors.New("broken")
+	r := io.MultiReader(strings.NewReader("1: 2 3: {"), iotest.ErrReader(errBroken))
+	if _, err := NewScannerReader(r).Exec(); !errors.Is(err, errBroken) {
+		t.Fatalf("expected %v, got %v", errBroken, err)
+	}
+}
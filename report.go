@@ -0,0 +1,87 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+// A Report describes how the disassembler interpreted each field it
+// encountered, as returned by WriteReport.
+type Report struct {
+	// Fields lists every field in the output, in the order they appear.
+	Fields []FieldReport
+}
+
+// FieldReport describes how a single field was disassembled.
+type FieldReport struct {
+	Number uint64
+	Class  FieldClass
+	// Depth is the number of messages and groups that enclose this field.
+	Depth int
+	// Offset is the offset of this field's tag from the start of the input.
+	Offset int
+}
+
+// FieldClass is the interpretation the disassembler chose for a field.
+type FieldClass int
+
+const (
+	ClassVarint FieldClass = iota
+	ClassFixed
+	ClassMessage
+	ClassString
+	ClassBytes
+	// ClassFailed indicates that the field's tag was well-formed, but its
+	// contents could not be decoded, so it was printed as hex along with the
+	// rest of its enclosing message.
+	ClassFailed
+)
+
+// String converts a FieldClass to a string.
+func (c FieldClass) String() string {
+	switch c {
+	case ClassVarint:
+		return "varint"
+	case ClassFixed:
+		return "fixed"
+	case ClassMessage:
+		return "message"
+	case ClassString:
+		return "string"
+	case ClassBytes:
+		return "bytes"
+	case ClassFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Failed returns the number of fields that failed to decode.
+func (r *Report) Failed() int {
+	n := 0
+	for _, f := range r.Fields {
+		if f.Class == ClassFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteReport is like Write, but also reports how each field was
+// interpreted, which can be used to judge how much of the output is
+// guesswork.
+func WriteReport(src []byte, opts WriterOptions) (string, Report) {
+	var report Report
+	text := write(src, opts, &report)
+	return text, report
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteReport(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		opts WriterOptions
+		want []FieldReport
+	}{
+		{
+			name: "scalars",
+			text: `1: 5 2: 1.5 3: 2i32`,
+			want: []FieldReport{
+				{Number: 1, Class: ClassVarint},
+				{Number: 2, Class: ClassFixed, Offset: 2},
+				{Number: 3, Class: ClassFixed, Offset: 11},
+			},
+		},
+		{
+			name: "length-prefixed",
+			text: "1: {2: 3} 4: {\"text\"} 5: {`00ff`}",
+			want: []FieldReport{
+				{Number: 1, Class: ClassMessage},
+				{Number: 2, Class: ClassVarint, Depth: 1, Offset: 2},
+				{Number: 4, Class: ClassString, Offset: 4},
+				{Number: 5, Class: ClassBytes, Offset: 10},
+			},
+		},
+		{
+			name: "groups",
+			text: `1: !{2: 3}`,
+			want: []FieldReport{
+				{Number: 1, Class: ClassMessage},
+				{Number: 2, Class: ClassVarint, Depth: 1, Offset: 1},
+			},
+		},
+		{
+			name: "truncated",
+			text: "1: 2 3:I64 `0000`",
+			want: []FieldReport{
+				{Number: 1, Class: ClassVarint},
+				{Number: 3, Class: ClassFailed, Offset: 2},
+			},
+		},
+		{
+			name: "partial message",
+			text: "1: {2: 3 4:LEN 5}",
+			opts: WriterOptions{AllFieldsAreMessages: true},
+			want: []FieldReport{
+				{Number: 1, Class: ClassMessage},
+				{Number: 2, Class: ClassVarint, Depth: 1, Offset: 2},
+				{Number: 4, Class: ClassFailed, Depth: 1, Offset: 4},
+			},
+		},
+		{
+			name: "packed",
+			text: `90: {1 2 3}`,
+			opts: WriterOptions{Schema: GetDesc("unittest.TestPackedTypes")},
+			want: []FieldReport{
+				{Number: 90, Class: ClassVarint},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			text, report := WriteReport(src, tt.opts)
+			if want := Write(src, tt.opts); text != want {
+				t.Errorf("WriteReport() text differs from Write(): got %q, want %q", text, want)
+			}
+			if d := cmp.Diff(tt.want, report.Fields); d != "" {
+				t.Fatal("report mismatch (-want, +got):", d)
+			}
+		})
+	}
+}
@@ -16,16 +16,28 @@
 package protoscope
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"math"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	_ "embed"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/protocolbuffers/protoscope/internal/print"
+	"github.com/protocolbuffers/protoscope/wire"
 )
 
 // The contents of language.text.
@@ -52,18 +64,40 @@ func (p Position) String() string {
 	return fmt.Sprintf("%s:%d:%d", file, p.Line+1, p.Column+1)
 }
 
-// A tokenKind is a kind of token.
-type tokenKind int
+// A TokenKind is a kind of Token.
+type TokenKind int
 
 const (
-	tokenBytes tokenKind = iota
-	tokenLongForm
-	tokenLeftCurly
-	tokenRightCurly
-	tokenGroupCurly
-	tokenEOF
+	// TokenBytes is any token that emits bytes: a literal, number, or tag.
+	TokenBytes TokenKind = iota
+	// TokenLongForm is a long-form:N modifier.
+	TokenLongForm
+	// TokenLeftCurly is a {.
+	TokenLeftCurly
+	// TokenRightCurly is a }.
+	TokenRightCurly
+	// TokenGroupCurly is a !{.
+	TokenGroupCurly
+	// TokenEOF marks the end of the input.
+	TokenEOF
+	// TokenEndGroup is the egroup keyword.
+	TokenEndGroup
+	// TokenRepeat is a repeat:N operator.
+	TokenRepeat
+	// TokenFill is a fill:N operator.
+	TokenFill
+	// TokenCRC32 is a crc32{, which is closed by a TokenRightCurly.
+	TokenCRC32
+	// TokenLabel is a @name{, which is closed by a TokenRightCurly.
+	TokenLabel
+	// TokenLength is a len(name) reference to a label.
+	TokenLength
 )
 
+// tokenRaw is a comment, macro definition, macro call, or long-form-default:N,
+// which the Scanner only returns as a token of its own if raw is set.
+const tokenRaw TokenKind = -1
+
 // A ParseError may be produced while executing a Protoscope file, wrapping
 // another error along with a position.
 //
@@ -86,14 +120,66 @@ func (e *ParseError) Unwrap() error {
 	return e.Err
 }
 
-// A token is a token in a Protoscope file.
-type token struct {
+// PrettyContext renders the lines of src around the error, numbered, with a
+// caret pointing at the column where the error occurred. context is the number
+// of lines to show on either side of the error's line.
+//
+// src should be the input that produced the error.
+func (e *ParseError) PrettyContext(src string, context int) string {
+	lines := strings.Split(src, "\n")
+	if e.Pos.Line >= len(lines) {
+		return ""
+	}
+
+	first := e.Pos.Line - context
+	if first < 0 {
+		first = 0
+	}
+	last := e.Pos.Line + context
+	if last >= len(lines) {
+		last = len(lines) - 1
+	}
+	width := len(strconv.Itoa(last + 1))
+
+	var out strings.Builder
+	for i := first; i <= last; i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		fmt.Fprintf(&out, "%*d | %s\n", width, i+1, line)
+		if i != e.Pos.Line {
+			continue
+		}
+
+		// Columns count bytes, so we need to convert them into something that
+		// lines up on a terminal. Tabs are reproduced as-is, so that they expand
+		// to the same width as they do in the line above.
+		col := e.Pos.Column
+		if col > len(line) {
+			col = len(line)
+		}
+		fmt.Fprintf(&out, "%*s | ", width, "")
+		for _, r := range line[:col] {
+			if r == '\t' {
+				out.WriteByte('\t')
+				continue
+			}
+			out.WriteString(strings.Repeat(" ", print.DisplayWidth(string(r))))
+		}
+		out.WriteString("^\n")
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// A Token is a token in a Protoscope file, as returned by Scanner.Next.
+//
+// Comments, whitespace, def, and long-form-default:N do not produce tokens,
+// and macro calls produce the tokens of their expansions.
+type Token struct {
 	// Kind is the kind of the token.
-	Kind tokenKind
-	// Value, for a tokenBytes token, is the decoded value of the token in
+	Kind TokenKind
+	// Value, for a TokenBytes token, is the decoded value of the token in
 	// bytes.
 	Value []byte
-	// WireType, for a tokenBytes token, is which wire type an InferredType
+	// WireType, for a TokenBytes token, is which wire type an InferredType
 	// tag expression that preceded it should become.
 	WireType int
 	// InferredType indicates that this was a tag expression which wishes to infer
@@ -101,12 +187,25 @@ type token struct {
 	InferredType bool
 	// Pos is the position of the first byte of the token.
 	Pos Position
-	// Length, for a tokenLongForm token, is the number of bytes to use to
+	// Length, for a TokenLongForm token, is the number of bytes to use to
 	// encode the length, not including the initial one.
+	//
+	// For a TokenLeftCurly, TokenRightCurly, TokenEndGroup, or TokenLength
+	// token, it is the Length of the long-form:N that immediately preceded it,
+	// or -1 if there was none.
+	//
+	// For a TokenRepeat token, it is the number of times to repeat what
+	// follows, and for a TokenFill token, it is the length to fill up to.
 	Length int
+	// Label, for a TokenLabel or TokenLength token, is the name of the label.
+	Label string
 	// FieldNumber, if not -1, indicates that this was a tag token. This is used
 	// for implementing group syntax.
 	FieldNumber int64
+	// Comment, if Scanner.KeepComments is set, is the text of the # comment
+	// that ends the line this token ends, if this is the last token on that
+	// line, without the # and surrounding whitespace.
+	Comment string
 }
 
 var (
@@ -115,10 +214,18 @@ var (
 	// 2: The encoding format.
 	// 3: The wire type, including the colon, if this is a tag.
 	// 4: The wire type expression, which may be empty if it is inferred.
-	regexpIntOrTag = regexp.MustCompile(`^-?([0-9]+|0x[0-9a-fA-F]+)(z|i32|i64)?(:(\w*))?$`)
-	regexpDecFp    = regexp.MustCompile(`^(-?[0-9]+\.[0-9]+(?:[eE]-?[0-9]+)?)(i32|i64)?$`)
-	regexpHexFp    = regexp.MustCompile(`^(-?0x[0-9a-fA-F]+\.[0-9a-fA-F]+(?:[pP]-?[0-9]+)?)(i32|i64)?$`)
-	regexpLongForm = regexp.MustCompile(`^long-form:([0-9]+)$`)
+	regexpIntOrTag        = regexp.MustCompile(`^-?([0-9]+|0x[0-9a-fA-F]+|0b[01]+)(z|i32|i64|zi32|zi64)?(:(\w*))?$`)
+	regexpDecFp           = regexp.MustCompile(`^(-?[0-9]+\.[0-9]+(?:[eE][-+]?[0-9]+)?)(i32|i64)?$`)
+	regexpHexFp           = regexp.MustCompile(`^(-?0x[0-9a-fA-F]+(?:\.[0-9a-fA-F]+(?:[pP][-+]?[0-9]+)?|[pP][-+]?[0-9]+))(i32|i64)?$`)
+	regexpLongForm        = regexp.MustCompile(`^long-form:([0-9]+)$`)
+	regexpLongFormDefault = regexp.MustCompile(`^long-form-default:([0-9]+)$`)
+	regexpRepeat          = regexp.MustCompile(`^repeat:([0-9]+)$`)
+	regexpFill            = regexp.MustCompile(`^fill:([0-9]+)$`)
+	regexpLabel           = regexp.MustCompile(`^@([a-zA-Z_][a-zA-Z0-9_]*)$`)
+	regexpLength          = regexp.MustCompile(`^len\(([a-zA-Z_][a-zA-Z0-9_]*)\)$`)
+	// 1: The field name.
+	// 2: The wire type expression, which may be empty if it is inferred.
+	regexpFieldName = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*):(\w*)$`)
 )
 
 // A Scanner represents parsing state for a Protoscope file.
@@ -129,21 +236,175 @@ var (
 // settings.
 type Scanner struct {
 	// Input is the input text being processed.
+	//
+	// For a Scanner created with NewScannerReader, this is only the part of
+	// the input that has been read so far and not yet discarded.
 	Input string
+	// MaxDepth, if positive, is the maximum number of {} blocks that may be
+	// nested inside of each other. This guards against running out of stack on
+	// pathological inputs.
+	MaxDepth int
+	// Schema, if not nil, is the message that the input encodes. Tag
+	// expressions may then name a field, as in name:, in place of its number.
+	//
+	// The {} or !{} after a tag for a message or group field encodes that
+	// field's type, so its fields may be named too.
+	Schema protoreflect.MessageDescriptor
+	// Strict, if set, rejects anything that cannot appear in a canonical
+	// encoding: long-form:N and long-form-default:N, which are the only ways to
+	// write a non-minimal varint, and groups.
+	Strict bool
+	// KeepComments, if set, attaches each # comment that follows a token on
+	// the same line to that token as its Comment, and to the SourceSpans it
+	// produces. The output is unaffected.
+	KeepComments bool
 	// Position is the current position at which parsing should
 	// resume. The Offset field is used for indexing into Input; the remaining
 	// fields are used for error-reporting.
 	pos Position
+	// file is the path passed to SetFile, which Reset returns to.
+	file string
+
+	// longFormDefault is the long-form:N padding applied to varints and
+	// length prefixes with no explicit long-form:N, set by long-form-default:N.
+	longFormDefault int
+	// lengthModifier is the long-form:N token most recently returned by Next,
+	// if it has yet to be applied to anything.
+	lengthModifier *Token
+
+	// spans is the source map built by ExecWithSourceMap, if recordSpans is
+	// set.
+	spans       []SourceSpan
+	recordSpans bool
+
+	// errs is the list of errors found by ExecAll, if recovering is set.
+	errs       []*ParseError
+	recovering bool
+
+	// depth is the number of {} blocks we are currently inside of.
+	depth int
+
+	// schemas is the stack of messages encoded by the {} and !{} blocks we are
+	// currently inside of, with nil for blocks of unknown type, and fieldSchema
+	// is the message type of the field whose tag was last executed, if any,
+	// which the next block will encode.
+	schemas     []protoreflect.MessageDescriptor
+	fieldSchema protoreflect.MessageDescriptor
+
+	// reader, if not nil, is where the rest of the input comes from, and base
+	// is the offset of the start of Input within everything it has produced.
+	// readErr is the error, if any, that reader failed with.
+	reader  io.Reader
+	base    int
+	readErr error
+
+	// sink is the io.Writer passed to ExecTo, if any, and flushed is how many
+	// bytes have been written to it so far.
+	sink    io.Writer
+	flushed int
+
+	// ctx is the context passed to ExecContext, if any, and steps counts how
+	// many tokens have been processed, so that we can check it periodically.
+	ctx   context.Context
+	steps int
+
+	// Macros defined with def, and the stack of macro expansions currently
+	// being read from.
+	macros     map[string]*macro
+	expansions []expansion
+
+	// queued holds the files added with AddFile that have yet to be read.
+	queued []queuedFile
+
+	// labels is the length of the contents of each @name{} block executed so
+	// far, by name.
+	labels map[string]int
+
+	// raw, if set, makes lex return comments, macro definitions, macro calls,
+	// and long-form-default:N as tokenRaw tokens, rather than skipping or
+	// expanding them, so that Format can reproduce them. end is the position
+	// just past the last token lexed, not including any trailing comment.
+	raw bool
+	end Position
 }
 
+// A labelRef is a len(name) whose label had yet to be defined when it was
+// executed. Its varint is inserted at offset in the output of the block it
+// appears in, once that block ends.
+type labelRef struct {
+	token          Token
+	offset         int
+	lengthOverride int
+}
+
+// A queuedFile is a file to be read once the Scanner's Input is exhausted.
+type queuedFile struct {
+	path, input string
+}
+
+// DefaultMaxDepth is the MaxDepth of a Scanner created with NewScanner.
+const DefaultMaxDepth = 10000
+
 // NewScanner creates a new scanner for parsing the given input.
 func NewScanner(input string) *Scanner {
-	return &Scanner{Input: input}
+	return &Scanner{Input: input, MaxDepth: DefaultMaxDepth}
+}
+
+// Assemble assembles src into bytes in one call, like
+// NewScanner(src).Exec().
+func Assemble(src string) ([]byte, error) {
+	return NewScanner(src).Exec()
+}
+
+// AssembleFile is like Assemble, but reports errors as being in the file at
+// path.
+func AssembleFile(path, src string) ([]byte, error) {
+	s := NewScanner(src)
+	s.SetFile(path)
+	return s.Exec()
+}
+
+// Reset prepares the Scanner to parse a new input, discarding everything about
+// the previous one, such as macro definitions and queued files, but reusing
+// its memory where possible. The file path set by SetFile and the exported
+// options, such as MaxDepth, are kept.
+//
+// A Scanner created with NewScannerReader stops reading from its reader.
+func (s *Scanner) Reset(input string) {
+	for name := range s.macros {
+		delete(s.macros, name)
+	}
+	*s = Scanner{
+		Input:        input,
+		MaxDepth:     s.MaxDepth,
+		Schema:       s.Schema,
+		Strict:       s.Strict,
+		KeepComments: s.KeepComments,
+		pos:          Position{File: s.file},
+		file:         s.file,
+
+		// spans and errs are returned to the caller, so they cannot be reused.
+		macros:     s.macros,
+		expansions: s.expansions[:0],
+		schemas:    s.schemas[:0],
+		queued:     s.queued[:0],
+	}
 }
 
 // SetFile sets the file path shown in this Scanner's error reports.
 func (s *Scanner) SetFile(path string) {
 	s.pos.File = path
+	s.file = path
+}
+
+// AddFile queues up input to be read from the file at path once Input is
+// exhausted, as if the two had been concatenated.
+//
+// Definitions, long-form-default directives, and open braces all carry over
+// from one file to the next, but errors report positions relative to the
+// file they occur in.
+func (s *Scanner) AddFile(path, input string) {
+	s.queued = append(s.queued, queuedFile{path, input})
 }
 
 // Exec consumes tokens until Input is exhausted, returning the resulting
@@ -152,10 +413,125 @@ func (s *Scanner) Exec() ([]byte, error) {
 	return s.exec(nil)
 }
 
+// ExecContext is like Exec, but gives up and returns ctx.Err() if ctx is
+// cancelled or its deadline passes before execution finishes.
+func (s *Scanner) ExecContext(ctx context.Context) ([]byte, error) {
+	s.ctx = ctx
+	s.steps = 0
+	defer func() { s.ctx = nil }()
+	return s.exec(nil)
+}
+
+// ExecTo is like Exec, but writes the output to w as it is produced, rather
+// than returning it all at once.
+//
+// Only output outside of {} blocks can be written early, since the contents
+// of a block are needed to encode its length prefix. If an error occurs, some
+// output may have been written already.
+func (s *Scanner) ExecTo(w io.Writer) error {
+	s.sink = w
+	s.flushed = 0
+	defer func() { s.sink = nil }()
+	out, err := s.exec(nil)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// writeChunk is how much output ExecTo accumulates before writing it.
+const writeChunk = 4096
+
+// flush writes out to the io.Writer passed to ExecTo, if there is one and out
+// is large enough, and then truncates it.
+func (s *Scanner) flush(out *[]byte) error {
+	if s.sink == nil || len(*out) < writeChunk {
+		return nil
+	}
+	if _, err := s.sink.Write(*out); err != nil {
+		return err
+	}
+	s.flushed += len(*out)
+	*out = (*out)[:0]
+	return nil
+}
+
+// checkContext returns the error of the context passed to ExecContext, if it
+// is done. To keep this cheap, it only actually looks every few tokens.
+func (s *Scanner) checkContext() error {
+	if s.ctx == nil {
+		return nil
+	}
+	s.steps++
+	if s.steps%256 != 1 {
+		return nil
+	}
+	return s.ctx.Err()
+}
+
+// ExecAll is like Exec, but rather than stopping at the first error, it tries
+// to recover and keep going, so that as many errors as possible are reported
+// at once.
+//
+// After an error, parsing resumes at the next token, skipping the rest of any
+// macro expansion the error occurred in. Unbalanced braces are reported where
+// they are found, and are otherwise treated as if they were balanced, so that
+// one stray brace does not produce an error for every one after it.
+//
+// If any errors occur, the returned output is nil.
+func (s *Scanner) ExecAll() ([]byte, []*ParseError) {
+	s.recovering = true
+	s.errs = nil
+	defer func() {
+		s.recovering = false
+		s.errs = nil
+	}()
+
+	out, err := s.exec(nil)
+	if err != nil {
+		// This only happens when we fail to recover.
+		s.recover(err)
+	}
+	if len(s.errs) != 0 {
+		return nil, s.errs
+	}
+	return out, nil
+}
+
+// recover records err if the Scanner is collecting errors for ExecAll, and
+// returns whether parsing should continue.
+func (s *Scanner) recover(err error) bool {
+	if !s.recovering {
+		return false
+	}
+
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		pe = &ParseError{s.absolute(s.pos), err}
+	}
+	s.errs = append(s.errs, pe)
+
+	for len(s.expansions) != 0 {
+		s.popExpansion()
+	}
+	s.lengthModifier = nil
+	// Make sure that we don't keep failing at the same spot.
+	if pe.Pos == s.absolute(s.pos) {
+		s.advance(1)
+	}
+	return true
+}
+
 // isEOF returns whether the cursor is at least n bytes ahead of the end of the
 // input.
 func (s *Scanner) isEOF(n int) bool {
-	return s.pos.Offset+n >= len(s.Input)
+	for s.pos.Offset+n >= len(s.Input) {
+		if !s.fill() {
+			return true
+		}
+	}
+	return false
 }
 
 // advance advances the scanner's cursor n positions.
@@ -174,6 +550,11 @@ func (s *Scanner) advance(n int) {
 	}
 }
 
+// isBlockComment returns whether the cursor is at the start of a /* comment.
+func (s *Scanner) isBlockComment() bool {
+	return !s.isEOF(1) && strings.HasPrefix(s.Input[s.pos.Offset:], "/*")
+}
+
 // consume advances exactly n times and returns all source bytes between the
 // initial cursor position and excluding the final cursor position.
 //
@@ -193,54 +574,77 @@ func (s *Scanner) consume(n int) (string, bool) {
 // source bytes between the initial cursor position and excluding the given
 // byte. This function will advance past the searched-for byte.
 //
-// If EOF is reached before the byte is seen, the function returns false.
+// If EOF is reached before the byte is seen, the function consumes the rest of
+// the input and returns false.
 func (s *Scanner) consumeUntil(b byte) (string, bool) {
-	if i := strings.IndexByte(s.Input[s.pos.Offset:], b); i != -1 {
+	if i := s.index(string(b)); i != -1 {
 		text, _ := s.consume(i + 1)
 		return text[:i], true
 	}
+	s.advance(len(s.Input))
 	return "", false
 }
 
-// parseEscapeSequence parses a Protoscope escape sequence, returning the byte
-// it escapes.
+// parseEscapeSequence parses a Protoscope escape sequence, appending the bytes
+// it escapes to dst.
 //
 // Valid escapes are:
-// \n \" \\ \xNN \NNN
+// \n \t \r \" \' \\ \xNN \NNN \uNNNN \UNNNNNNNN
 //
 // This function assumes that the scanner's cursor is currently on a \ rune.
-func (s *Scanner) parseEscapeSequence() (byte, error) {
+func (s *Scanner) parseEscapeSequence(dst []byte) ([]byte, error) {
+	start := s.pos
 	s.advance(1) // Skip the \. The caller is assumed to have validated it.
 	if s.isEOF(0) {
-		return 0, &ParseError{s.pos, errors.New("expected escape character")}
+		return nil, &ParseError{s.pos, errors.New("expected escape character")}
 	}
 
 	switch c := s.Input[s.pos.Offset]; c {
 	case 'n':
 		s.advance(1)
-		return '\n', nil
-	case '"', '\\':
+		return append(dst, '\n'), nil
+	case 't':
+		s.advance(1)
+		return append(dst, '\t'), nil
+	case 'r':
+		s.advance(1)
+		return append(dst, '\r'), nil
+	case '"', '\'', '\\':
 		s.advance(1)
-		return c, nil
+		return append(dst, c), nil
 	case 'x':
 		s.advance(1)
 
 		hexes, ok := s.consume(2)
 		if !ok {
-			return 0, &ParseError{s.pos, errors.New("unfinished escape sequence")}
+			return nil, &ParseError{s.pos, errors.New("unfinished escape sequence")}
 		}
 
 		bytes, err := hex.DecodeString(hexes)
 		if err != nil {
-			return 0, &ParseError{s.pos, err}
+			return nil, &ParseError{s.pos, err}
 		}
+		return append(dst, bytes[0]), nil
+	case 'u', 'U':
+		// \u and \U escape a Unicode code point, which is encoded as UTF-8.
+		digits := 4
+		if c == 'U' {
+			digits = 8
+		}
+		s.advance(1)
 
-		var r byte
-		for _, b := range bytes {
-			r <<= 8
-			r |= b
+		hexes, ok := s.consume(digits)
+		if !ok {
+			return nil, &ParseError{s.pos, errors.New("unfinished escape sequence")}
 		}
-		return r, nil
+		r, err := strconv.ParseUint(hexes, 16, 32)
+		if err != nil {
+			return nil, &ParseError{start, err}
+		}
+		if !utf8.ValidRune(rune(r)) {
+			return nil, &ParseError{start, fmt.Errorf("invalid code point U+%04X", r)}
+		}
+		return utf8.AppendRune(dst, rune(r)), nil
 	case '0', '1', '2', '3', '4', '5', '6', '7':
 		start := s.pos.Offset
 		for i := 0; i < 3 && !s.isEOF(0); i++ {
@@ -253,35 +657,35 @@ func (s *Scanner) parseEscapeSequence() (byte, error) {
 		str := s.Input[start:s.pos.Offset]
 		r, err := strconv.ParseUint(str, 8, 8)
 		if err != nil {
-			return 0, &ParseError{s.pos, err}
+			return nil, &ParseError{s.pos, err}
 		}
-		return byte(r), nil
+		return append(dst, byte(r)), nil
 	default:
-		return 0, &ParseError{s.pos, fmt.Errorf("unknown escape sequence \\%c", c)}
+		return nil, &ParseError{s.pos, fmt.Errorf("unknown escape sequence \\%c", c)}
 	}
 }
 
 // parseQuotedString parses a UTF-8 string until the next ".
 //
 // This function assumes that the scanner's cursor is currently on a " rune.
-func (s *Scanner) parseQuotedString() (token, error) {
-	s.advance(1) // Skip the ". The caller is assumed to have validated it.
+func (s *Scanner) parseQuotedString() (Token, error) {
 	start := s.pos
+	s.advance(1) // Skip the ". The caller is assumed to have validated it.
 	var bytes []byte
 	for {
 		if s.isEOF(0) {
-			return token{}, &ParseError{start, errors.New("unmatched \"")}
+			return Token{}, &ParseError{start, errors.New("unmatched \"")}
 		}
 		switch c := s.Input[s.pos.Offset]; c {
 		case '"':
 			s.advance(1)
-			return token{Kind: tokenBytes, Value: bytes, Pos: start}, nil
+			return Token{Kind: TokenBytes, Value: bytes, Pos: start, FieldNumber: -1}, nil
 		case '\\':
-			r, err := s.parseEscapeSequence()
+			var err error
+			bytes, err = s.parseEscapeSequence(bytes)
 			if err != nil {
-				return token{}, err
+				return Token{}, err
 			}
-			bytes = append(bytes, r)
 		default:
 			s.advance(1)
 			bytes = append(bytes, c)
@@ -289,19 +693,195 @@ func (s *Scanner) parseQuotedString() (token, error) {
 	}
 }
 
-// next lexes the next token.
-func (s *Scanner) next(lengthModifier **token) (token, error) {
+// stripSpace removes all ASCII whitespace from s, so that long hex and base64
+// literals may be broken up across lines.
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// parseBase64 parses a base64 literal that began at start, and emits its
+// decoded bytes. Padding is optional.
+//
+// This function assumes that the scanner's cursor is currently on the ` rune
+// following the b64 prefix.
+func (s *Scanner) parseBase64(start Position) (Token, error) {
+	s.advance(1) // Skip the `. The caller is assumed to have validated it.
+	b64Str, ok := s.consumeUntil('`')
+	if !ok {
+		return Token{}, &ParseError{start, errors.New("unmatched `")}
+	}
+	bytes, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(stripSpace(b64Str), "="))
+	if err != nil {
+		return Token{}, &ParseError{start, err}
+	}
+	return Token{Kind: TokenBytes, Value: bytes, Pos: start, FieldNumber: -1}, nil
+}
+
+// parseCharLiteral parses a character literal, which contains a single
+// character or escape sequence, and emits its bytes.
+//
+// This function assumes that the scanner's cursor is currently on a ' rune.
+func (s *Scanner) parseCharLiteral() (Token, error) {
+	start := s.pos
+	s.advance(1) // Skip the '. The caller is assumed to have validated it.
+	if s.isEOF(0) {
+		return Token{}, &ParseError{start, errors.New("unmatched '")}
+	}
+
+	var bytes []byte
+	switch s.Input[s.pos.Offset] {
+	case '\'':
+		s.advance(1)
+		return Token{}, &ParseError{start, errors.New("empty character literal")}
+	case '\\':
+		var err error
+		bytes, err = s.parseEscapeSequence(nil)
+		if err != nil {
+			return Token{}, err
+		}
+	default:
+		s.isEOF(utf8.UTFMax - 1) // Make sure we have the whole rune.
+		_, n := utf8.DecodeRuneInString(s.Input[s.pos.Offset:])
+		text, _ := s.consume(n)
+		bytes = []byte(text)
+	}
+
+	if s.isEOF(0) || s.Input[s.pos.Offset] != '\'' {
+		if _, ok := s.consumeUntil('\''); !ok {
+			return Token{}, &ParseError{start, errors.New("unmatched '")}
+		}
+		return Token{}, &ParseError{start, errors.New("character literal must contain exactly one character")}
+	}
+	s.advance(1)
+	return Token{Kind: TokenBytes, Value: bytes, Pos: start, FieldNumber: -1}, nil
+}
+
+// Next returns the next token in the input, reading through any macro
+// expansions and on into any queued files. Once the input is exhausted, it
+// returns a TokenEOF token.
+//
+// A long-form:N is returned as a TokenLongForm token, and is then applied to
+// whatever follows it: a varint's Value is encoded with the extra bytes, and a
+// {, }, egroup, or len(name) token records it in its Length. A long-form:N
+// followed by anything else is an error.
+func (s *Scanner) Next() (Token, error) {
+	modifier := s.lengthModifier
+	tok, err := s.next(&s.lengthModifier)
+	if err != nil {
+		return Token{}, err
+	}
+	if tok.Kind == tokenRaw {
+		return tok, nil
+	}
+
+	switch tok.Kind {
+	case TokenLeftCurly, TokenRightCurly, TokenEndGroup, TokenLength:
+		tok.Length = -1
+		if modifier != nil {
+			tok.Length = modifier.Length
+		}
+	default:
+		// If a varint consumed the modifier, next will have cleared it.
+		if s.lengthModifier != nil {
+			return Token{}, &ParseError{modifier.Pos, errors.New("length modifier was not followed by '{', '}', or varint")}
+		}
+	}
+
+	s.lengthModifier = nil
+	if tok.Kind == TokenLongForm {
+		s.lengthModifier = &tok
+	}
+	return tok, nil
+}
+
+// next lexes the next token, reading through any macro expansions and on into
+// any queued files.
+func (s *Scanner) next(lengthModifier **Token) (Token, error) {
+	if len(s.expansions) == 0 {
+		s.compact()
+	}
+	for {
+		tok, err := s.lex(lengthModifier)
+		s.end = s.pos
+		if err == nil && s.KeepComments && tok.Kind != TokenEOF {
+			tok.Comment = s.trailingComment()
+		}
+		if len(s.expansions) == 0 {
+			if err == nil && tok.Kind == TokenEOF && s.readErr != nil {
+				err, s.readErr = s.readErr, nil
+			}
+			if err == nil && tok.Kind == TokenEOF && len(s.queued) != 0 {
+				s.Input = s.queued[0].input
+				s.pos = Position{File: s.queued[0].path}
+				s.reader, s.base = nil, 0
+				s.queued = s.queued[1:]
+				continue
+			}
+			return s.absoluteToken(tok, err)
+		}
+		if err != nil {
+			return s.absoluteToken(Token{}, s.inExpansion(err))
+		}
+		if tok.Kind == TokenEOF {
+			s.popExpansion()
+			continue
+		}
+
+		// Tokens produced by a macro are attributed to the call site.
+		tok.Pos = s.expansions[0].call
+		return s.absoluteToken(tok, nil)
+	}
+}
+
+// trailingComment consumes the # comment that ends the current line, if there
+// is nothing but spaces, commas, and semicolons before it, and returns its
+// text.
+func (s *Scanner) trailingComment() string {
+	start := s.pos
+	for !s.isEOF(0) {
+		c := s.Input[s.pos.Offset]
+		if c != ' ' && c != '\t' && c != ',' && c != ';' {
+			break
+		}
+		s.advance(1)
+	}
+	if s.isEOF(0) || s.Input[s.pos.Offset] != '#' {
+		s.pos = start
+		return ""
+	}
+	text := s.pos.Offset + 1
+	for !s.isEOF(0) && s.Input[s.pos.Offset] != '\n' {
+		s.advance(1)
+	}
+	return strings.TrimSpace(s.Input[text:s.pos.Offset])
+}
+
+// lex lexes the next token from the current input.
+func (s *Scanner) lex(lengthModifier **Token) (Token, error) {
 again:
 	if s.isEOF(0) {
-		return token{Kind: tokenEOF, Pos: s.pos}, nil
+		return Token{Kind: TokenEOF, Pos: s.pos, FieldNumber: -1}, nil
 	}
 
+	start := s.pos
 	switch s.Input[s.pos.Offset] {
-	case ' ', '\t', '\n', '\r':
-		// Skip whitespace.
+	case ' ', '\t', '\n', '\r', ',', ';':
+		// Skip whitespace, which includes commas and semicolons.
 		s.advance(1)
 		goto again
 	case '#':
+		if s.raw {
+			for !s.isEOF(0) && s.Input[s.pos.Offset] != '\n' {
+				s.advance(1)
+			}
+			return Token{Kind: tokenRaw, Pos: start, FieldNumber: -1}, nil
+		}
 		// Skip to the end of the comment.
 		s.advance(1)
 		for !s.isEOF(0) {
@@ -312,43 +892,66 @@ again:
 			}
 		}
 		goto again
+	case '/':
+		if !s.isBlockComment() {
+			break
+		}
+		// Skip to the end of the comment.
+		s.advance(2)
+		i := s.index("*/")
+		if i == -1 {
+			s.advance(len(s.Input))
+			return Token{}, &ParseError{start, errors.New("unterminated block comment")}
+		}
+		s.advance(i + 2)
+		if s.raw {
+			return Token{Kind: tokenRaw, Pos: start, FieldNumber: -1}, nil
+		}
+		goto again
 	case '!':
 		s.advance(1)
-		if s.Input[s.pos.Offset] != '{' {
-			return token{}, &ParseError{s.pos, errors.New("expected { after !")}
+		if s.isEOF(0) || s.Input[s.pos.Offset] != '{' {
+			return Token{}, &ParseError{s.pos, errors.New("expected { after !")}
 		}
 		s.advance(1)
-		return token{Kind: tokenGroupCurly, Pos: s.pos}, nil
+		return Token{Kind: TokenGroupCurly, Pos: start, FieldNumber: -1}, nil
 	case '{':
 		s.advance(1)
-		return token{Kind: tokenLeftCurly, Pos: s.pos}, nil
+		return Token{Kind: TokenLeftCurly, Pos: start, FieldNumber: -1}, nil
 	case '}':
 		s.advance(1)
-		return token{Kind: tokenRightCurly, Pos: s.pos}, nil
+		return Token{Kind: TokenRightCurly, Pos: start, FieldNumber: -1}, nil
 	case '"':
 		return s.parseQuotedString()
+	case '\'':
+		return s.parseCharLiteral()
 	case '`':
 		s.advance(1)
+		open := s.pos
 		hexStr, ok := s.consumeUntil('`')
 		if !ok {
-			return token{}, &ParseError{s.pos, errors.New("unmatched `")}
+			return Token{}, &ParseError{open, errors.New("unmatched `")}
 		}
-		bytes, err := hex.DecodeString(hexStr)
+		bytes, err := hex.DecodeString(stripSpace(hexStr))
 		if err != nil {
-			return token{}, &ParseError{s.pos, err}
+			return Token{}, &ParseError{s.pos, err}
 		}
-		return token{Kind: tokenBytes, Value: bytes, Pos: s.pos}, nil
+		return Token{Kind: TokenBytes, Value: bytes, Pos: start, FieldNumber: -1}, nil
 	}
 
 	// Normal token. Consume up to the next whitespace character, symbol, or
 	// EOF.
-	start := s.pos
 	s.advance(1)
 loop:
 	for !s.isEOF(0) {
 		switch s.Input[s.pos.Offset] {
-		case ' ', '\t', '\n', '\r', '{', '}', '[', ']', '`', '"', '#', '!':
+		case ' ', '\t', '\n', '\r', ',', ';', '{', '}', '[', ']', '`', '"', '#', '!':
 			break loop
+		case '/':
+			if s.isBlockComment() {
+				break loop
+			}
+			s.advance(1)
 		default:
 			s.advance(1)
 		}
@@ -356,19 +959,56 @@ loop:
 
 	symbol := s.Input[start.Offset:s.pos.Offset]
 
-	if match := regexpIntOrTag.FindStringSubmatch(symbol); match != nil {
-		// Go can detect the base if we set base=0, but it treats a leading 0 as
-		// octal.
-		base := 10
-		isHex := strings.HasPrefix(match[0], "0x") || strings.HasPrefix(match[0], "-0x")
-		if isHex {
-			base = 16
+	if symbol == "b64" && !s.isEOF(0) && s.Input[s.pos.Offset] == '`' {
+		return s.parseBase64(start)
+	}
+	if symbol == "crc32" && !s.isEOF(0) && s.Input[s.pos.Offset] == '{' {
+		s.advance(1)
+		return Token{Kind: TokenCRC32, Pos: start, FieldNumber: -1}, nil
+	}
+	if strings.HasPrefix(symbol, "@") && !s.isEOF(0) && s.Input[s.pos.Offset] == '{' {
+		match := regexpLabel.FindStringSubmatch(symbol)
+		if match == nil {
+			return Token{}, &ParseError{start, fmt.Errorf("invalid label name %q", symbol[1:])}
+		}
+		s.advance(1)
+		return Token{Kind: TokenLabel, Label: match[1], Pos: start, FieldNumber: -1}, nil
+	}
+	if symbol == "def" {
+		if err := s.parseDef(start); err != nil {
+			return Token{}, err
+		}
+		if s.raw {
+			return Token{Kind: tokenRaw, Pos: start, FieldNumber: -1}, nil
 		}
+		goto again
+	}
+	if i := strings.IndexByte(symbol, '('); i > 0 {
+		if m, ok := s.macros[symbol[:i]]; ok {
+			// The symbol may have stopped short of the closing parenthesis, so
+			// rewind to just after the opening one.
+			s.pos = start
+			s.advance(i + 1)
+			args, err := s.parseCall(m, start)
+			if err != nil {
+				return Token{}, err
+			}
+			if s.raw {
+				return Token{Kind: tokenRaw, Pos: start, FieldNumber: -1}, nil
+			}
+			if err := s.expand(m, args, start); err != nil {
+				return Token{}, err
+			}
+			goto again
+		}
+	}
 
+	if match := regexpIntOrTag.FindStringSubmatch(symbol); match != nil {
 		// Use ParseUint so that we get the biggest unsigned ints possible.
-		uvalue, err := strconv.ParseUint(strings.TrimPrefix(match[1], "0x"), base, 64)
+		digits, base := integerBase(match[1])
+		uvalue, err := strconv.ParseUint(digits, base, 64)
 		if err != nil {
-			return token{}, &ParseError{start, err}
+			return Token{}, &ParseError{start, err}
 		}
 		value := int64(uvalue)
 
@@ -376,7 +1016,7 @@ loop:
 		// same bit representation as 9223372036854775808, since it is MinInt64.
 		if strings.HasPrefix(match[0], "-") && value != math.MinInt64 {
 			if value < 0 {
-				return token{}, &ParseError{start, fmt.Errorf("negation overflows: '%s'", match[0])}
+				return Token{}, &ParseError{start, fmt.Errorf("negation overflows: '%s'", match[0])}
 			}
 			value = -value
 		}
@@ -384,44 +1024,18 @@ loop:
 		var fieldNumber int64 = -1
 		inferredType := false
 		if match[3] != "" {
-			if match[2] == "i32" || match[2] == "i64" {
-				return token{}, &ParseError{start, errors.New("cannot use fixed-width encoding on tag expressions")}
+			if strings.HasSuffix(match[2], "i32") || strings.HasSuffix(match[2], "i64") {
+				return Token{}, &ParseError{start, errors.New("cannot use fixed-width encoding on tag expressions")}
 			}
 
 			var wireType int64
-			switch match[4] {
-			case "":
-				inferredType = true
-			case "VARINT":
-				wireType = 0
-			case "I64":
-				wireType = 1
-			case "LEN":
-				wireType = 2
-			case "SGROUP":
-				wireType = 3
-			case "EGROUP":
-				wireType = 4
-			case "I32":
-				wireType = 5
-			default:
-				var err error
-				if strings.HasPrefix(match[4], "0x") {
-					wireType, err = strconv.ParseInt(match[4], 16, 64)
-				} else {
-					wireType, err = strconv.ParseInt(match[4], 10, 64)
-				}
-				if err != nil {
-					return token{}, &ParseError{start, err}
-				}
-			}
-
-			if wireType > 7 {
-				return token{}, &ParseError{start, errors.New("a tag's wire type must be between 0 and 7")}
+			wireType, inferredType, err = s.parseWireType(match[4], start)
+			if err != nil {
+				return Token{}, err
 			}
 
 			if value>>61 != 0 && value>>61 != -1 {
-				return token{}, &ParseError{start, errors.New("field number too large for three extra bits for the wire type.")}
+				return Token{}, &ParseError{start, errors.New("field number too large for three extra bits for the wire type.")}
 			}
 			fieldNumber = value
 
@@ -436,16 +1050,16 @@ loop:
 			value = (value << 1) ^ (value >> 63)
 			fallthrough
 		case "":
-			var len int
+			lenBytes := s.longFormDefault
 			if *lengthModifier != nil {
-				len = (*lengthModifier).Length
+				lenBytes = (*lengthModifier).Length
 				*lengthModifier = nil
 			}
-			enc = encodeVarint(nil, uint64(value), len)
+			enc = encodeVarint(nil, uint64(value), lenBytes)
 		case "i32":
 			wireType = 5
 			if value > math.MaxUint32 || value < math.MinInt32 {
-				return token{}, &ParseError{start, fmt.Errorf("'%s' does not fit in 32 bits", symbol)}
+				return Token{}, &ParseError{start, fmt.Errorf("'%s' does not fit in 32 bits", symbol)}
 			}
 			if value > math.MinInt32 {
 				value -= math.MaxUint32 + 1
@@ -456,20 +1070,57 @@ loop:
 			wireType = 1
 			enc = make([]byte, 8)
 			binary.LittleEndian.PutUint64(enc, uint64(value))
+		case "zi32":
+			// Zigzag encoding maps [-2^31, 2^31) onto [0, 2^32).
+			wireType = 5
+			if value > math.MaxInt32 || value < math.MinInt32 {
+				return Token{}, &ParseError{start, fmt.Errorf("'%s' does not fit in 32 bits", symbol)}
+			}
+			enc = make([]byte, 4)
+			binary.LittleEndian.PutUint32(enc, uint32((int32(value)<<1)^(int32(value)>>31)))
+		case "zi64":
+			wireType = 1
+			value = (value << 1) ^ (value >> 63)
+			enc = make([]byte, 8)
+			binary.LittleEndian.PutUint64(enc, uint64(value))
 		default:
 			panic("unreachable")
 		}
 
-		return token{
-			Kind:         tokenBytes,
+		return Token{
+			Kind:         TokenBytes,
 			InferredType: inferredType,
 			WireType:     wireType,
 			Value:        enc,
-			Pos:          s.pos,
+			Pos:          start,
 			FieldNumber:  fieldNumber,
 		}, nil
 	}
 
+	if match := regexpLength.FindStringSubmatch(symbol); match != nil {
+		return Token{Kind: TokenLength, Label: match[1], Pos: start, FieldNumber: -1}, nil
+	}
+
+	// These must come before field names, which they would otherwise look like.
+	if match := regexpRepeat.FindStringSubmatch(symbol); match != nil {
+		n, err := strconv.ParseInt(match[1], 10, 32)
+		if err != nil {
+			return Token{}, &ParseError{start, err}
+		}
+		return Token{Kind: TokenRepeat, Length: int(n), Pos: start, FieldNumber: -1}, nil
+	}
+	if match := regexpFill.FindStringSubmatch(symbol); match != nil {
+		n, err := strconv.ParseInt(match[1], 10, 32)
+		if err != nil {
+			return Token{}, &ParseError{start, err}
+		}
+		return Token{Kind: TokenFill, Length: int(n), Pos: start, FieldNumber: -1}, nil
+	}
+
+	if match := regexpFieldName.FindStringSubmatch(symbol); match != nil {
+		return s.parseFieldName(match[1], match[2], start, lengthModifier)
+	}
+
 	match := regexpDecFp.FindStringSubmatch(symbol)
 	if match == nil {
 		match = regexpHexFp.FindStringSubmatch(symbol)
@@ -491,10 +1142,10 @@ loop:
 			wireType = 5
 			value, err := strconv.ParseFloat(fp, 32)
 			if err != nil {
-				return token{}, &ParseError{start, err}
+				return Token{}, &ParseError{start, err}
 			}
 			if math.IsInf(value, 0) || math.IsNaN(value) || math.Abs(value) > math.MaxFloat32 {
-				return token{}, &ParseError{start, fmt.Errorf("'%s' does not fit in a IEEE 754 binary32", match[0])}
+				return Token{}, &ParseError{start, fmt.Errorf("'%s' does not fit in a IEEE 754 binary32", match[0])}
 			}
 			enc = make([]byte, 4)
 			binary.LittleEndian.PutUint32(enc, math.Float32bits(float32(value)))
@@ -502,10 +1153,10 @@ loop:
 			wireType = 1
 			value, err := strconv.ParseFloat(fp, 64)
 			if err != nil {
-				return token{}, &ParseError{start, err}
+				return Token{}, &ParseError{start, err}
 			}
 			if math.IsInf(value, 0) || math.IsNaN(value) {
-				return token{}, &ParseError{start, fmt.Errorf("'%s' does not fit in a IEEE 754 binary64", match[0])}
+				return Token{}, &ParseError{start, fmt.Errorf("'%s' does not fit in a IEEE 754 binary64", match[0])}
 			}
 			enc = make([]byte, 8)
 			binary.LittleEndian.PutUint64(enc, math.Float64bits(value))
@@ -513,66 +1164,201 @@ loop:
 			panic("unreachable")
 		}
 
-		return token{
-			Kind:        tokenBytes,
+		return Token{
+			Kind:        TokenBytes,
 			WireType:    wireType,
 			Value:       enc,
-			Pos:         s.pos,
+			Pos:         start,
 			FieldNumber: -1,
 		}, nil
 	}
 
+	if s.Strict && (regexpLongFormDefault.MatchString(symbol) || regexpLongForm.MatchString(symbol)) {
+		return Token{}, &ParseError{start, fmt.Errorf("%s is not allowed in strict mode", symbol)}
+	}
+
+	if match := regexpLongFormDefault.FindStringSubmatch(symbol); match != nil {
+		l, err := strconv.ParseInt(match[1], 10, 32)
+		if err != nil {
+			return Token{}, &ParseError{start, err}
+		}
+		s.longFormDefault = int(l)
+		if s.raw {
+			return Token{Kind: tokenRaw, Pos: start, FieldNumber: -1}, nil
+		}
+		goto again
+	}
+
 	if match := regexpLongForm.FindStringSubmatch(symbol); match != nil {
 		l, err := strconv.ParseInt(match[1], 10, 32)
 		if err != nil {
-			return token{}, &ParseError{start, err}
+			return Token{}, &ParseError{start, err}
 		}
-		return token{Kind: tokenLongForm, Length: int(l), Pos: s.pos}, nil
+		return Token{Kind: TokenLongForm, Length: int(l), Pos: start, FieldNumber: -1}, nil
 	}
 
 	switch symbol {
 	case "true":
-		return token{Kind: tokenBytes, Value: []byte{1}, Pos: s.pos, FieldNumber: -1}, nil
+		return Token{Kind: TokenBytes, Value: []byte{1}, Pos: start, FieldNumber: -1}, nil
 	case "false":
-		return token{Kind: tokenBytes, Value: []byte{0}, Pos: s.pos, FieldNumber: -1}, nil
+		return Token{Kind: TokenBytes, Value: []byte{0}, Pos: start, FieldNumber: -1}, nil
 	case "inf32":
-		return token{Kind: tokenBytes, WireType: 5, Value: []byte{0x00, 0x00, 0x80, 0x7f}, Pos: s.pos, FieldNumber: -1}, nil
+		return Token{Kind: TokenBytes, WireType: 5, Value: []byte{0x00, 0x00, 0x80, 0x7f}, Pos: start, FieldNumber: -1}, nil
 	case "-inf32":
-		return token{Kind: tokenBytes, WireType: 5, Value: []byte{0x00, 0x00, 0x80, 0xff}, Pos: s.pos, FieldNumber: -1}, nil
+		return Token{Kind: TokenBytes, WireType: 5, Value: []byte{0x00, 0x00, 0x80, 0xff}, Pos: start, FieldNumber: -1}, nil
 	case "inf64":
-		return token{Kind: tokenBytes, WireType: 1, Value: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x7f}, Pos: s.pos, FieldNumber: -1}, nil
+		return Token{Kind: TokenBytes, WireType: 1, Value: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x7f}, Pos: start, FieldNumber: -1}, nil
 	case "-inf64":
-		return token{Kind: tokenBytes, WireType: 1, Value: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0xff}, Pos: s.pos, FieldNumber: -1}, nil
+		return Token{Kind: TokenBytes, WireType: 1, Value: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0xff}, Pos: start, FieldNumber: -1}, nil
+	case "nan32":
+		return Token{Kind: TokenBytes, WireType: 5, Value: []byte{0x00, 0x00, 0xc0, 0x7f}, Pos: start, FieldNumber: -1}, nil
+	case "nan64":
+		return Token{Kind: TokenBytes, WireType: 1, Value: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x7f}, Pos: start, FieldNumber: -1}, nil
+	case "egroup":
+		return Token{Kind: TokenEndGroup, Pos: start, FieldNumber: -1}, nil
+	}
+
+	return Token{}, &ParseError{start, fmt.Errorf("unrecognized symbol %q", symbol)}
+}
+
+// parseWireType parses the wire type expression after the : of a tag, which
+// may be empty if the wire type is to be inferred. In strict mode, the group
+// wire types are rejected.
+func (s *Scanner) parseWireType(expr string, start Position) (wireType int64, inferred bool, err error) {
+	switch expr {
+	case "":
+		return 0, true, nil
+	case "VARINT":
+		wireType = 0
+	case "I64":
+		wireType = 1
+	case "LEN":
+		wireType = 2
+	case "SGROUP":
+		wireType = 3
+	case "EGROUP":
+		wireType = 4
+	case "I32":
+		wireType = 5
+	default:
+		digits, base := integerBase(expr)
+		wireType, err = strconv.ParseInt(digits, base, 64)
+		if err != nil {
+			return 0, false, &ParseError{start, err}
+		}
+	}
+
+	if wireType > 7 {
+		return 0, false, &ParseError{start, errors.New("a tag's wire type must be between 0 and 7")}
+	}
+	if s.Strict && (wireType == 3 || wireType == 4) {
+		return 0, false, &ParseError{start, errors.New("group tags are not allowed in strict mode")}
+	}
+	return wireType, false, nil
+}
+
+// parseFieldName lexes a tag expression that names its field, such as
+// name:LEN, resolving the name against the message that the current {} block
+// encodes.
+func (s *Scanner) parseFieldName(name, wireTypeExpr string, start Position, lengthModifier **Token) (Token, error) {
+	desc := s.currentSchema()
+	if desc == nil {
+		if s.Schema == nil {
+			return Token{}, &ParseError{start, fmt.Errorf("field name %q used without a schema", name)}
+		}
+		return Token{}, &ParseError{start, fmt.Errorf("field name %q used in a block of unknown type", name)}
+	}
+	fd := desc.Fields().ByName(protoreflect.Name(name))
+	if fd == nil {
+		return Token{}, &ParseError{start, fmt.Errorf("no field named %q in %s", name, desc.FullName())}
 	}
 
-	return token{}, fmt.Errorf("unrecognized symbol %q", symbol)
+	wireType, inferred, err := s.parseWireType(wireTypeExpr, start)
+	if err != nil {
+		return Token{}, err
+	}
+
+	lenBytes := s.longFormDefault
+	if *lengthModifier != nil {
+		lenBytes = (*lengthModifier).Length
+		*lengthModifier = nil
+	}
+	number := int64(fd.Number())
+	return Token{
+		Kind:         TokenBytes,
+		InferredType: inferred,
+		Value:        encodeVarint(nil, uint64(number<<3|wireType), lenBytes),
+		Pos:          start,
+		FieldNumber:  number,
+	}, nil
+}
+
+// messageField returns the message type of the field with the given number in
+// the message that the innermost {} block being executed encodes, or nil if
+// it is not a message or group field.
+func (s *Scanner) messageField(number int64) protoreflect.MessageDescriptor {
+	desc := s.currentSchema()
+	if desc == nil {
+		return nil
+	}
+	fd := desc.Fields().ByNumber(protoreflect.FieldNumber(number))
+	if fd == nil {
+		return nil
+	}
+	return fd.Message()
+}
+
+// currentSchema returns the message that the innermost {} block being executed
+// encodes, or nil if it is not known.
+func (s *Scanner) currentSchema() protoreflect.MessageDescriptor {
+	if len(s.schemas) == 0 {
+		return s.Schema
+	}
+	return s.schemas[len(s.schemas)-1]
 }
 
 // exec is the main parser loop.
 //
-// The leftCurly argument, it not nil, represents the { that began the
-// length-prefixed block we're currently executing. Because we need to encode
+// The leftCurly argument, it not nil, represents the { or crc32{ that began the
+// prefixed block we're currently executing. Because we need to encode
 // the full extent of the contents of a {} before emitting the length prefix,
 // this function calls itself with a non-nil leftCurly to encode it.
-func (s *Scanner) exec(leftCurly *token) ([]byte, error) {
+func (s *Scanner) exec(leftCurly *Token) ([]byte, error) {
 	var out []byte
-	var lengthModifier *token
 	var groupStack []int64
+	// tagGroups is the field numbers of the groups opened with an explicit
+	// SGROUP tag in this block that have yet to be closed with an EGROUP tag.
+	var tagGroups []int64
+	// refs is the len(name)s in this block whose labels are not yet defined,
+	// and firstSpan is the index of the first span recorded for this block.
+	var refs []labelRef
+	firstSpan := len(s.spans)
 	inferredTypeIndex := -1
-	lastToken := token{FieldNumber: -1}
+	lastToken := Token{FieldNumber: -1}
 	for {
-		token, err := s.next(&lengthModifier)
-		if err != nil {
+		if err := s.checkContext(); err != nil {
 			return nil, err
 		}
-		if lengthModifier != nil && token.Kind != tokenLeftCurly && !(token.Kind == tokenRightCurly && len(groupStack) != 0) {
-			return nil, &ParseError{lengthModifier.Pos, errors.New("length modifier was not followed by '{', '}', or varint")}
+		// At the top level, once the type of the last tag is known and every
+		// len(name) has been filled in, the output so far is final.
+		if leftCurly == nil && inferredTypeIndex == -1 && len(refs) == 0 {
+			if err := s.flush(&out); err != nil {
+				return nil, err
+			}
+		}
+
+		token, err := s.Next()
+		if err != nil {
+			if s.recover(err) {
+				continue
+			}
+			return nil, err
 		}
 		prevToken := lastToken
 		lastToken = token
 
 		switch token.Kind {
-		case tokenBytes:
+		case TokenBytes:
 			if inferredTypeIndex != -1 {
 				out[inferredTypeIndex] |= byte(token.WireType)
 				inferredTypeIndex = -1
@@ -581,35 +1367,152 @@ func (s *Scanner) exec(leftCurly *token) ([]byte, error) {
 			if token.InferredType {
 				inferredTypeIndex = len(out)
 			}
+			s.fieldSchema = nil
+			if token.FieldNumber != -1 {
+				s.fieldSchema = s.messageField(token.FieldNumber)
+				if !token.InferredType {
+					tagGroups = trackTagGroup(tagGroups, token)
+				}
+			}
+			s.addSpan(len(out), len(out)+len(token.Value), token)
 			out = append(out, token.Value...)
-		case tokenLongForm:
-			lengthModifier = &token
-		case tokenLeftCurly:
+		case TokenLongForm:
+			// Next takes care of applying this to the next token.
+		case TokenLeftCurly:
 			if inferredTypeIndex != -1 {
 				out[inferredTypeIndex] |= 2
 				inferredTypeIndex = -1
 			}
 
+			if s.MaxDepth > 0 && s.depth >= s.MaxDepth {
+				return nil, &ParseError{token.Pos, errors.New("maximum nesting depth exceeded")}
+			}
+
+			first := len(s.spans)
+			s.depth++
+			s.schemas = append(s.schemas, s.fieldSchema)
+			s.fieldSchema = nil
 			child, err := s.exec(&token)
+			s.schemas = s.schemas[:len(s.schemas)-1]
+			s.depth--
 			if err != nil {
 				return nil, err
 			}
-			var lengthOverride int
-			if lengthModifier != nil {
-				lengthOverride = lengthModifier.Length
+			lengthOverride := s.longFormDefault
+			if token.Length >= 0 {
+				lengthOverride = token.Length
 			}
+			start := len(out)
 			out = encodeVarint(out, uint64(len(child)), lengthOverride)
+			s.nestSpans(first, start, len(out), token)
 			out = append(out, child...)
-			lengthModifier = nil
-		case tokenGroupCurly:
+		case TokenCRC32:
+			// A checksum is not a value that the tag's type could be inferred
+			// from.
+			if inferredTypeIndex != -1 {
+				err := &ParseError{token.Pos, errors.New("crc32{ cannot come between a tag and its value")}
+				if !s.recover(err) {
+					return nil, err
+				}
+				inferredTypeIndex = -1
+			}
+
+			if s.MaxDepth > 0 && s.depth >= s.MaxDepth {
+				return nil, &ParseError{token.Pos, errors.New("maximum nesting depth exceeded")}
+			}
+
+			first := len(s.spans)
+			s.depth++
+			s.schemas = append(s.schemas, s.fieldSchema)
+			s.fieldSchema = nil
+			child, err := s.exec(&token)
+			s.schemas = s.schemas[:len(s.schemas)-1]
+			s.depth--
+			if err != nil {
+				return nil, err
+			}
+			start := len(out)
+			out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(child))
+			s.nestSpans(first, start, len(out), token)
+			out = append(out, child...)
+		case TokenLabel:
+			// A label's contents have no length prefix, so the tag's type cannot be
+			// inferred from them.
+			if inferredTypeIndex != -1 {
+				err := &ParseError{token.Pos, errors.New("a label cannot come between a tag and its value")}
+				if !s.recover(err) {
+					return nil, err
+				}
+				inferredTypeIndex = -1
+			}
+
+			if _, ok := s.labels[token.Label]; ok {
+				err := &ParseError{token.Pos, fmt.Errorf("label %q is already defined", token.Label)}
+				if !s.recover(err) {
+					return nil, err
+				}
+			}
+			if s.MaxDepth > 0 && s.depth >= s.MaxDepth {
+				return nil, &ParseError{token.Pos, errors.New("maximum nesting depth exceeded")}
+			}
+
+			first := len(s.spans)
+			s.depth++
+			s.schemas = append(s.schemas, s.fieldSchema)
+			s.fieldSchema = nil
+			child, err := s.exec(&token)
+			s.schemas = s.schemas[:len(s.schemas)-1]
+			s.depth--
+			if err != nil {
+				return nil, err
+			}
+			if s.labels == nil {
+				s.labels = make(map[string]int)
+			}
+			s.labels[token.Label] = len(child)
+			s.shiftSpans(first, len(out))
+			out = append(out, child...)
+		case TokenLength:
+			if inferredTypeIndex != -1 {
+				inferredTypeIndex = -1
+			}
+
+			lengthOverride := s.longFormDefault
+			if token.Length >= 0 {
+				lengthOverride = token.Length
+			}
+			n, ok := s.labels[token.Label]
+			if !ok {
+				refs = append(refs, labelRef{token, len(out), lengthOverride})
+				continue
+			}
+			start := len(out)
+			out = encodeVarint(out, uint64(n), lengthOverride)
+			s.addSpan(start, len(out), token)
+		case TokenGroupCurly:
+			if s.Strict {
+				err := &ParseError{token.Pos, errors.New("groups are not allowed in strict mode")}
+				if !s.recover(err) {
+					return nil, err
+				}
+			}
 			if prevToken.FieldNumber == -1 || inferredTypeIndex == -1 {
-				return nil, &ParseError{token.Pos, errors.New("group !{} must immediately follow untyped field number")}
+				err := &ParseError{token.Pos, errors.New("group !{} must immediately follow untyped field number")}
+				if !s.recover(err) {
+					return nil, err
+				}
+				// Keep track of the group anyways, so that its } is not unmatched.
+				groupStack = append(groupStack, prevToken.FieldNumber)
+				s.schemas = append(s.schemas, nil)
+				continue
 			}
 
 			out[inferredTypeIndex] |= byte(3)
 			inferredTypeIndex = -1
 			groupStack = append(groupStack, prevToken.FieldNumber)
-		case tokenRightCurly:
+			s.schemas = append(s.schemas, s.fieldSchema)
+			s.fieldSchema = nil
+		case TokenRightCurly:
 			if inferredTypeIndex != -1 {
 				inferredTypeIndex = -1
 			}
@@ -617,51 +1520,300 @@ func (s *Scanner) exec(leftCurly *token) ([]byte, error) {
 			if len(groupStack) != 0 {
 				innerGroup := groupStack[len(groupStack)-1]
 				groupStack = groupStack[:len(groupStack)-1]
+				s.schemas = s.schemas[:len(s.schemas)-1]
 
-				var lengthOverride int
-				if lengthModifier != nil {
-					lengthOverride = lengthModifier.Length
+				lengthOverride := s.longFormDefault
+				if token.Length >= 0 {
+					lengthOverride = token.Length
 				}
+				start := len(out)
 				out = encodeVarint(out, uint64(innerGroup<<3|4), lengthOverride)
-				lengthModifier = nil
+				s.addSpan(start, len(out), token)
+			} else if token.Length >= 0 {
+				err := &ParseError{token.Pos, errors.New("length modifier was not followed by '{', '}', or varint")}
+				if !s.recover(err) {
+					return nil, err
+				}
+				if leftCurly != nil {
+					return s.resolveRefs(out, refs, firstSpan)
+				}
 			} else if leftCurly != nil {
-				return out, nil
+				return s.resolveRefs(out, refs, firstSpan)
 			} else {
-				return nil, &ParseError{token.Pos, errors.New("unmatched '}'")}
+				err := &ParseError{token.Pos, errors.New("unmatched '}'")}
+				if !s.recover(err) {
+					return nil, err
+				}
+			}
+		case TokenEndGroup:
+			if inferredTypeIndex != -1 {
+				inferredTypeIndex = -1
+			}
+
+			if len(tagGroups) == 0 {
+				err := &ParseError{token.Pos, errors.New("egroup without a matching SGROUP tag")}
+				if !s.recover(err) {
+					return nil, err
+				}
+				continue
+			}
+			number := tagGroups[len(tagGroups)-1]
+			tagGroups = tagGroups[:len(tagGroups)-1]
+
+			lengthOverride := s.longFormDefault
+			if token.Length >= 0 {
+				lengthOverride = token.Length
+			}
+			start := len(out)
+			out = encodeVarint(out, uint64(number<<3|4), lengthOverride)
+			s.addSpan(start, len(out), token)
+		case TokenRepeat:
+			// Repeating only the value would leave the tag's type to be
+			// inferred from a value that is not really its own.
+			if inferredTypeIndex != -1 {
+				err := &ParseError{token.Pos, errors.New("repeat:N cannot come between a tag and its value")}
+				if !s.recover(err) {
+					return nil, err
+				}
+				continue
+			}
+
+			first := len(s.spans)
+			repeated, err := s.execRepeat(token)
+			if err != nil {
+				return nil, err
 			}
-		case tokenEOF:
+			s.shiftSpans(first, len(out))
+			out = append(out, repeated...)
+		case TokenFill:
+			if inferredTypeIndex != -1 {
+				inferredTypeIndex = -1
+			}
+
+			if len(refs) != 0 {
+				err := &ParseError{token.Pos, fmt.Errorf("fill:N after len(%s) of a label that is not yet defined", refs[0].token.Label)}
+				if !s.recover(err) {
+					return nil, err
+				}
+				continue
+			}
+
+			// At the top level, the output includes what has been flushed.
+			size := len(out)
+			if leftCurly == nil {
+				size += s.flushed
+			}
+			first := len(s.spans)
+			filler, err := s.execFill(token, size)
+			if err != nil {
+				return nil, err
+			}
+			s.shiftSpans(first, len(out))
+			out = append(out, filler...)
+		case TokenEOF:
 			if inferredTypeIndex != -1 {
 				inferredTypeIndex = -1
 			}
 
 			if leftCurly == nil && len(groupStack) == 0 {
-				return out, nil
+				return s.resolveRefs(out, refs, firstSpan)
+			}
+			pos := prevToken.Pos
+			if leftCurly != nil && len(groupStack) == 0 {
+				pos = leftCurly.Pos
 			}
-			return nil, &ParseError{prevToken.Pos, errors.New("unmatched '{'")}
+			err := &ParseError{pos, errors.New("unmatched '{'")}
+			if !s.recover(err) {
+				return nil, err
+			}
+			return s.resolveRefs(out, refs, firstSpan)
 		default:
 			panic(token)
 		}
 	}
 }
 
-// encodeVarint encodes a varint to dest.
+// resolveRefs inserts the varints for refs, the len(name)s in a block whose
+// labels were not yet defined when they were executed, into out, the block's
+// output, once it has ended. Spans recorded since index firstSpan are
+// relative to the start of out.
+func (s *Scanner) resolveRefs(out []byte, refs []labelRef, firstSpan int) ([]byte, error) {
+	// Go backwards, so that inserting a varint does not move the offsets of
+	// the ones left to insert.
+	for i := len(refs) - 1; i >= 0; i-- {
+		ref := refs[i]
+		n, ok := s.labels[ref.token.Label]
+		if !ok {
+			err := &ParseError{ref.token.Pos, fmt.Errorf("label %q is not defined by the end of the block that refers to it", ref.token.Label)}
+			if !s.recover(err) {
+				return nil, err
+			}
+			continue
+		}
+		varint := encodeVarint(nil, uint64(n), ref.lengthOverride)
+		out = slices.Insert(out, ref.offset, varint...)
+		s.insertSpan(firstSpan, ref.offset, len(varint), ref.token)
+	}
+	return out, nil
+}
+
+// maxRepeatSize is the largest number of bytes that a single repeat:N or fill:N
+// may expand to. This guards against running out of memory on absurd counts.
+const maxRepeatSize = 64 << 20
+
+// execRepeat executes the value or {} block that follows the repeat:N token
+// repeat, and returns its bytes repeated N times. A {} block is repeated
+// without a length prefix; write {{...}} to repeat a length-prefixed value.
 //
-// Unlike binary.PutUvarint, this function allows encoding non-minimal varints.
-func encodeVarint(dest []byte, value uint64, longForm int) []byte {
-	for value > 0x7f {
-		dest = append(dest, byte(value&0x7f)|0x80)
-		value >>= 7
+// Any spans recorded are relative to the start of the returned bytes.
+func (s *Scanner) execRepeat(repeat Token) ([]byte, error) {
+	first := len(s.spans)
+	token, err := s.Next()
+	for err == nil && token.Kind == TokenLongForm {
+		// Next takes care of applying this to the next token.
+		token, err = s.Next()
+	}
+	if err != nil {
+		return nil, err
 	}
-	dest = append(dest, byte(value))
 
-	if longForm > 0 {
-		dest[len(dest)-1] |= 0x80
-		for longForm > 1 {
-			dest = append(dest, 0x80)
-			longForm--
+	var value []byte
+	switch token.Kind {
+	case TokenBytes:
+		if token.InferredType {
+			err := &ParseError{token.Pos, errors.New("cannot repeat a tag with an inferred type")}
+			if !s.recover(err) {
+				return nil, err
+			}
+			return nil, nil
+		}
+		s.fieldSchema = nil
+		value = token.Value
+		s.addSpan(0, len(value), token)
+	case TokenRepeat:
+		value, err = s.execRepeat(token)
+		if err != nil {
+			return nil, err
+		}
+	case TokenLeftCurly:
+		if token.Length >= 0 {
+			err := &ParseError{token.Pos, errors.New("a repeated '{' has no length prefix to modify")}
+			if !s.recover(err) {
+				return nil, err
+			}
+		}
+		if s.MaxDepth > 0 && s.depth >= s.MaxDepth {
+			return nil, &ParseError{token.Pos, errors.New("maximum nesting depth exceeded")}
+		}
+
+		// The block's contents belong to the enclosing message.
+		s.depth++
+		s.schemas = append(s.schemas, s.currentSchema())
+		s.fieldSchema = nil
+		value, err = s.exec(&token)
+		s.schemas = s.schemas[:len(s.schemas)-1]
+		s.depth--
+		if err != nil {
+			return nil, err
+		}
+	default:
+		err := &ParseError{token.Pos, errors.New("repeat:N was not followed by a value or '{'")}
+		if !s.recover(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	if len(value) > 0 && repeat.Length > maxRepeatSize/len(value) {
+		err := &ParseError{repeat.Pos, fmt.Errorf("repeat:%d of %d bytes exceeds the limit of %d bytes", repeat.Length, len(value), maxRepeatSize)}
+		if !s.recover(err) {
+			return nil, err
 		}
-		dest = append(dest, 0x00)
+		s.spans = s.spans[:first]
+		return nil, nil
 	}
+	s.repeatSpans(first, len(value), repeat.Length)
+	return bytes.Repeat(value, repeat.Length), nil
+}
 
-	return dest
+// execFill executes the value that follows the fill:N token fill, and returns
+// as many copies of it as it takes to bring output of size bytes up to N bytes.
+//
+// Any spans recorded are relative to the start of the returned bytes.
+func (s *Scanner) execFill(fill Token, size int) ([]byte, error) {
+	token, err := s.Next()
+	for err == nil && token.Kind == TokenLongForm {
+		// Next takes care of applying this to the next token.
+		token, err = s.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Kind != TokenBytes || token.InferredType || len(token.Value) == 0 {
+		err := &ParseError{token.Pos, errors.New("fill:N was not followed by a non-empty value")}
+		if !s.recover(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	s.fieldSchema = nil
+
+	gap := fill.Length - size
+	switch {
+	case gap < 0:
+		err = fmt.Errorf("fill:%d after %d bytes of output", fill.Length, size)
+	case gap > maxRepeatSize:
+		err = fmt.Errorf("fill:%d of %d bytes exceeds the limit of %d bytes", fill.Length, gap, maxRepeatSize)
+	case gap%len(token.Value) != 0:
+		err = fmt.Errorf("fill:%d cannot fill %d bytes with a %d-byte value", fill.Length, gap, len(token.Value))
+	}
+	if err != nil {
+		err := &ParseError{fill.Pos, err}
+		if !s.recover(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	s.addSpan(0, gap, token)
+	return bytes.Repeat(token.Value, gap/len(token.Value)), nil
+}
+
+// trackTagGroup updates the stack of groups opened with explicit SGROUP tags
+// for the tag token, which had an explicit wire type.
+//
+// An EGROUP tag only closes the innermost group if its field number matches;
+// otherwise, it is emitted as written, like any other tag.
+func trackTagGroup(groups []int64, token Token) []int64 {
+	tag, _, _, _ := wire.ConsumeVarint(token.Value)
+	switch tag & 7 {
+	case 3:
+		groups = append(groups, token.FieldNumber)
+	case 4:
+		if len(groups) != 0 && groups[len(groups)-1] == token.FieldNumber {
+			groups = groups[:len(groups)-1]
+		}
+	}
+	return groups
+}
+
+// integerBase returns the digits of an unsigned integer literal, without any
+// 0x or 0b prefix, along with their base.
+//
+// Go can detect the base if we set base=0, but it treats a leading 0 as octal.
+func integerBase(lit string) (string, int) {
+	switch {
+	case strings.HasPrefix(lit, "0x"):
+		return lit[2:], 16
+	case strings.HasPrefix(lit, "0b"):
+		return lit[2:], 2
+	default:
+		return lit, 10
+	}
+}
+
+// encodeVarint encodes a varint to dest; see wire.AppendVarint.
+func encodeVarint(dest []byte, value uint64, longForm int) []byte {
+	return wire.AppendVarint(dest, value, longForm)
 }
@@ -15,10 +15,15 @@
 package protoscope
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -153,6 +158,64 @@ func TestScan(t *testing.T) {
 			name: "bad escape",
 			text: `"\a"`,
 		},
+		{
+			name: "whitespace escapes",
+			text: `"a\tb\r\n"`,
+			want: []byte("a\tb\r\n"),
+		},
+		{
+			name: "unicode escapes",
+			text: `"\u4e2d\u6587" "\U0001F408" "\u0041"`,
+			want: []byte("中文🐈A"),
+		},
+		{
+			name: "unicode escape surrogate",
+			text: `"\ud800"`,
+		},
+		{
+			name: "unicode escape too big",
+			text: `"\U00110000"`,
+		},
+		{
+			name: "unicode escape too short",
+			text: `"\u12"`,
+		},
+		{
+			name: "char literals",
+			text: `'a' 'b''\n' '\x41' ' ' '中' '\''`,
+			want: []byte("ab\nA 中'"),
+		},
+		{
+			name: "char literal in macro call",
+			text: "def f(x) = x\nf(',')",
+			want: []byte(","),
+		},
+		{
+			name: "unterminated char literal",
+			text: `'a`,
+		},
+		{
+			name: "empty char literal",
+			text: `''`,
+		},
+		{
+			name: "multi-rune char literal",
+			text: `'ab'`,
+		},
+		{
+			name: "octal escapes",
+			text: `"\0" "\13" "\007" "\377"`,
+			want: []byte{0x00, 0x0b, 0x07, 0xff},
+		},
+		{
+			name: "octal escape stops at non-octal",
+			text: `"\08" "\1234"`,
+			want: []byte{0x00, '8', 0x53, '4'},
+		},
+		{
+			name: "octal escape too big",
+			text: `"\400"`,
+		},
 
 		{
 			name: "zero",
@@ -195,6 +258,24 @@ func TestScan(t *testing.T) {
 			text: "0x5a",
 			want: []byte{0x5a},
 		},
+		{
+			name: "binary",
+			text: "0b1010 -0b1 0b11111111",
+			want: []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0xff, 0x01},
+		},
+		{
+			name: "binary too big",
+			text: "0b1" + strings.Repeat("0", 64),
+		},
+		{
+			name: "bad binary digit",
+			text: "0b102",
+		},
+		{
+			name: "hex and binary wire types",
+			text: "1:0x5 2:0b101",
+			want: []byte{0x0d, 0x15},
+		},
 		{
 			name: "two hex byte",
 			text: "0xa5",
@@ -327,6 +408,137 @@ func TestScan(t *testing.T) {
 				num2le(float32(math.Inf(-1))),
 			),
 		},
+		{
+			name: "block comment",
+			text: "1 /* 2 */ 3/* 4\n5 { */6 /**/",
+			want: []byte{1, 3, 6},
+		},
+		{
+			name: "commas",
+			text: "1, 2,3 ,4,, {5, 6},",
+			want: []byte{1, 2, 3, 4, 0x02, 5, 6},
+		},
+		{
+			name: "semicolons",
+			text: "1; 2;3 ;4;; {5; 6};",
+			want: []byte{1, 2, 3, 4, 0x02, 5, 6},
+		},
+		{
+			name: "commas and semicolons",
+			text: "1: {1, 2, 3}; 2: 4;",
+			want: []byte{0x0a, 3, 1, 2, 3, 0x10, 4},
+		},
+		{
+			name: "semicolons in strings",
+			text: `"a; b" ';'`,
+			want: []byte("a; b;"),
+		},
+		{
+			name: "commas in strings",
+			text: `"a, b" 'a' ','`,
+			want: []byte("a, ba,"),
+		},
+		{
+			name: "commas in macro",
+			text: "def f(x, y) = x, y\nf(1, 2), f(3,4)",
+			want: []byte{1, 2, 3, 4},
+		},
+		{
+			name: "block comment does not nest",
+			text: "/* /* */ 1 */",
+		},
+		{
+			name: "unterminated block comment",
+			text: "1 /* 2",
+		},
+		{
+			name: "slash is not a comment",
+			text: "1/2",
+		},
+		{
+			name: "block comment in macro",
+			text: "def f(x) = { x /* x } */ }\nf(1 /* ) */)",
+			want: []byte{0x01, 0x01},
+		},
+		{
+			name: "hex with whitespace",
+			text: "`de ad be ef` `\n\tca fe\r\n00\n`",
+			want: []byte{0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0x00},
+		},
+		{
+			name: "hex with whitespace odd digits",
+			text: "`de ad b`",
+		},
+		{
+			name: "hex with whitespace bad digit",
+			text: "`de ad bg`",
+		},
+		{
+			name: "hex digit split by whitespace",
+			text: "`d e`",
+			want: []byte{0xde},
+		},
+		{
+			name: "base64 with whitespace",
+			text: "b64`aGVs\nbG8=`",
+			want: []byte("hello"),
+		},
+		{
+			name: "base64",
+			text: "b64`AAEC` b64`aGVsbG8=` b64`aGVsbG8` b64``",
+			want: []byte("\x00\x01\x02hellohello"),
+		},
+		{
+			name: "base64 bad character",
+			text: "b64`aGk*`",
+		},
+		{
+			name: "base64 unmatched",
+			text: "b64`aGk=",
+		},
+		{
+			name: "base64 needs adjacent backtick",
+			text: "b64 `aGk=`",
+		},
+		{
+			name: "zigzag fixed-width",
+			text: "0zi32 -1zi32 1zi32 -2147483648zi32 2147483647zi32 -1zi64 -9223372036854775808zi64",
+			want: []byte{
+				0x00, 0x00, 0x00, 0x00,
+				0x01, 0x00, 0x00, 0x00,
+				0x02, 0x00, 0x00, 0x00,
+				0xff, 0xff, 0xff, 0xff,
+				0xfe, 0xff, 0xff, 0xff,
+				0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			},
+		},
+		{
+			name: "zigzag fixed-width too big",
+			text: "2147483648zi32",
+		},
+		{
+			name: "zigzag fixed-width tag",
+			text: "1zi32:",
+		},
+		{
+			name: "negative infinity sets the sign bit",
+			text: "-inf32 -inf64",
+			want: []byte{
+				0x00, 0x00, 0x80, 0xff,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0xff,
+			},
+		},
+		{
+			name: "nan",
+			text: "nan32 nan64 1: nan32 2: nan64",
+			want: []byte{
+				0x00, 0x00, 0xc0, 0x7f,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x7f,
+				0x0d, 0x00, 0x00, 0xc0, 0x7f,
+				0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x7f,
+			},
+		},
 
 		{
 			name: "plank",
@@ -352,6 +564,285 @@ func TestScan(t *testing.T) {
 				num2le(float32(0x1.8p5)),
 			),
 		},
+		{
+			name: "hex floats without a fraction",
+			text: `
+				0x1p0
+				0x1p5i32
+				-0x3p-10
+				0x1p+2i64
+				0x1p-10i32
+			`,
+			want: concat(
+				num2le(0x1p0),
+				num2le(float32(0x1p5)),
+				num2le(-0x3p-10),
+				num2le(0x1p+2),
+				num2le(float32(0x1p-10)),
+			),
+		},
+		{
+			name: "egroup",
+			text: "1:SGROUP 2:SGROUP 3: 4 egroup long-form:1 egroup",
+			want: []byte{0x0b, 0x13, 0x18, 0x04, 0x14, 0x8c, 0x00},
+		},
+		{
+			name: "egroup after explicit EGROUP",
+			text: "1:SGROUP 2:SGROUP 2:EGROUP egroup",
+			want: []byte{0x0b, 0x13, 0x14, 0x0c},
+		},
+		{
+			name: "egroup after mismatched EGROUP",
+			text: "1:SGROUP 2:EGROUP egroup",
+			want: []byte{0x0b, 0x14, 0x0c},
+		},
+		{
+			name: "egroup in nested block",
+			text: "1:SGROUP 2: {3:SGROUP egroup} egroup",
+			want: []byte{0x0b, 0x12, 0x02, 0x1b, 0x1c, 0x0c},
+		},
+		{
+			name: "egroup outside of block",
+			text: "1:SGROUP 2: {egroup}",
+		},
+		{
+			name: "egroup without SGROUP",
+			text: "egroup",
+		},
+		{
+			name: "egroup after group syntax",
+			text: "1: !{} egroup",
+		},
+		{
+			name: "repeat",
+			text: `repeat:3 1 repeat:2 "ab" repeat:2 2:VARINT`,
+			want: []byte{1, 1, 1, 'a', 'b', 'a', 'b', 0x10, 0x10},
+		},
+		{
+			name: "repeat zero times",
+			text: "1 repeat:0 2 3",
+			want: []byte{1, 3},
+		},
+		{
+			name: "repeat block",
+			text: "repeat:2 {1: 5 2: {}}",
+			want: []byte{0x08, 0x05, 0x12, 0x00, 0x08, 0x05, 0x12, 0x00},
+		},
+		{
+			name: "repeat length-prefixed block",
+			text: `1: {repeat:2 {{"x"}}}`,
+			want: []byte{0x0a, 0x04, 0x01, 'x', 0x01, 'x'},
+		},
+		{
+			name: "repeat group",
+			text: "repeat:2 {1: !{}}",
+			want: []byte{0x0b, 0x0c, 0x0b, 0x0c},
+		},
+		{
+			name: "repeat repeat",
+			text: "repeat:2 repeat:3 7",
+			want: []byte{7, 7, 7, 7, 7, 7},
+		},
+		{
+			name: "repeat long-form",
+			text: "repeat:2 long-form:1 1",
+			want: []byte{0x81, 0x00, 0x81, 0x00},
+		},
+		{
+			name: "repeat in macro",
+			text: "def f(n, x) = repeat:n x\nf(3, 4)",
+			want: []byte{4, 4, 4},
+		},
+		{
+			name: "repeat inferred tag",
+			text: "repeat:2 1: 5",
+		},
+		{
+			name: "repeat between tag and value",
+			text: "1: repeat:2 5",
+		},
+		{
+			name: "repeat after explicit tag",
+			text: "1:VARINT repeat:2 5",
+			want: []byte{0x08, 5, 5},
+		},
+		{
+			name: "repeat long-form block",
+			text: "repeat:2 long-form:1 {}",
+		},
+		{
+			name: "repeat right curly",
+			text: "1: {repeat:2}",
+		},
+		{
+			name: "repeat at EOF",
+			text: "repeat:2",
+		},
+		{
+			name: "repeat too large",
+			text: "repeat:100000 repeat:100000 1",
+		},
+		{
+			name: "fill",
+			text: "1 2 fill:5 0",
+			want: []byte{1, 2, 0, 0, 0},
+		},
+		{
+			name: "fill when already full",
+			text: "1 2 fill:2 0",
+			want: []byte{1, 2},
+		},
+		{
+			name: "fill block",
+			text: `1: {"ab" fill:4 0xff}`,
+			want: []byte{0x0a, 0x04, 'a', 'b', 0xff, 0x01},
+		},
+		{
+			name: "fill group",
+			text: `1: {2: !{"a" fill:5 0}}`,
+			want: []byte{0x0a, 0x06, 0x13, 'a', 0, 0, 0, 0x14},
+		},
+		{
+			name: "fill in repeat",
+			text: "repeat:2 {1 fill:3 0}",
+			want: []byte{1, 0, 0, 1, 0, 0},
+		},
+		{
+			name: "fill past",
+			text: "1 2 3 fill:2 0",
+		},
+		{
+			name: "fill unevenly",
+			text: `1 fill:4 "ab"`,
+		},
+		{
+			name: "fill with empty value",
+			text: `fill:4 ""`,
+		},
+		{
+			name: "fill with inferred tag",
+			text: "fill:4 1: 2",
+		},
+		{
+			name: "fill right curly",
+			text: "1: {fill:4}",
+		},
+		{
+			name: "fill too large",
+			text: "fill:2000000000 0",
+		},
+		{
+			name: "crc32",
+			text: `crc32{"123456789"}`,
+			want: []byte{0x26, 0x39, 0xf4, 0xcb, '1', '2', '3', '4', '5', '6', '7', '8', '9'},
+		},
+		{
+			name: "crc32 empty",
+			text: "crc32{}",
+			want: []byte{0, 0, 0, 0},
+		},
+		{
+			name: "crc32 of fields",
+			text: `1: {crc32{2: 3 4: {"x"}}}`,
+			want: []byte{0x0a, 0x09, 0xb0, 0x95, 0x7b, 0xc8, 0x10, 0x03, 0x22, 0x01, 'x'},
+		},
+		{
+			name: "crc32 with space",
+			text: "crc32 {}",
+		},
+		{
+			name: "crc32 unmatched",
+			text: "crc32{1",
+		},
+		{
+			name: "crc32 long-form",
+			text: "long-form:1 crc32{}",
+		},
+		{
+			name: "crc32 between tag and value",
+			text: "1: crc32{2: 3}",
+		},
+		{
+			name: "crc32 after explicit tag",
+			text: "1:I32 crc32{}",
+			want: []byte{0x0d, 0, 0, 0, 0},
+		},
+		{
+			name: "label",
+			text: "@a{1 2} len(a)",
+			want: []byte{1, 2, 2},
+		},
+		{
+			name: "label referred to before",
+			text: `len(a) @a{"abc"}`,
+			want: []byte{3, 'a', 'b', 'c'},
+		},
+		{
+			name: "label long-form",
+			text: `long-form:1 len(a) 9 @a{"abc"}`,
+			want: []byte{0x83, 0x00, 9, 'a', 'b', 'c'},
+		},
+		{
+			name: "label in nested block",
+			text: `1: {len(a) 2: {@a{"xy"}}}`,
+			want: []byte{0x0a, 0x05, 0x02, 0x12, 0x02, 'x', 'y'},
+		},
+		{
+			name: "labels referred to together",
+			text: `len(a) len(b) @b{"x"} @a{"yy"}`,
+			want: []byte{2, 1, 'x', 'y', 'y'},
+		},
+		{
+			name: "labels nested",
+			text: `len(a) @a{len(b) @b{"x"}}`,
+			want: []byte{2, 1, 'x'},
+		},
+		{
+			name: "label in macro",
+			text: "def f(n) = len(n) @n{\"x\"}\nf(a) f(b)",
+			want: []byte{1, 'x', 1, 'x'},
+		},
+		{
+			name: "label between tag and value",
+			text: "1: @a{2: 3}",
+		},
+		{
+			name: "label after explicit tag",
+			text: "1:LEN len(a) @a{2: 3}",
+			want: []byte{0x0a, 2, 0x10, 3},
+		},
+		{
+			name: "label undefined",
+			text: "len(a)",
+		},
+		{
+			name: "label defined after block",
+			text: "1: {len(a)} @a{}",
+		},
+		{
+			name: "label redefined",
+			text: "@a{} @a{}",
+		},
+		{
+			name: "label referred to inside itself",
+			text: "@a{len(a)}",
+		},
+		{
+			name: "label after fill",
+			text: "len(a) fill:4 0 @a{}",
+		},
+		{
+			name: "label invalid name",
+			text: "@1{}",
+		},
+		{
+			name: "label unmatched",
+			text: "@a{",
+		},
+		{
+			name: "hex float without a fraction or exponent",
+			text: "0x1p",
+		},
 
 		{
 			name: "oct null",
@@ -460,7 +951,8 @@ func TestScan(t *testing.T) {
 		},
 		{
 			name: "plus exponent",
-			text: "1.0e+1",
+			text: "1.0e+1 1.0E+1i32 0x1.0p+4",
+			want: concat(num2le(10.0), num2le(float32(10.0)), num2le(16.0)),
 		},
 		{
 			name: "long float",
@@ -669,44 +1161,134 @@ func TestScan(t *testing.T) {
 			text: "1:SGROUP !{}",
 		},
 
+		{
+			name: "long-form default",
+			text: "long-form-default:2 1: 2 3: {4} 5: !{} long-form-default:0 6",
+			want: concat(
+				0x88, 0x80, 0x00, 0x82, 0x80, 0x00,
+				0x9a, 0x80, 0x00, 0x83, 0x80, 0x00, 0x84, 0x80, 0x00,
+				0xab, 0x80, 0x00, 0xac, 0x80, 0x00,
+				0x06,
+			),
+		},
+		{
+			name: "long-form default override",
+			text: "long-form-default:1 long-form:2 1 long-form:0 2 3",
+			want: concat(0x81, 0x80, 0x00, 0x02, 0x83, 0x00),
+		},
+
+		{
+			name: "macro",
+			text: `def header(n) = n: { 1: 0 2: {"hdr"} }
+			header(5) header(6)`,
+			want: concat(
+				0x2a, 0x07, 0x08, 0x00, 0x12, 0x03, "hdr",
+				0x32, 0x07, 0x08, 0x00, 0x12, 0x03, "hdr",
+			),
+		},
+		{
+			name: "macro multiple params",
+			text: `def pair(a, b) = 1: a 2: {
+				b  # a isn't substituted in comments
+			}
+			pair(7, "a, b")`,
+			want: concat(0x08, 0x07, 0x12, 0x04, "a, b"),
+		},
+		{
+			name: "macro no params",
+			text: "def x() = 1: 1\nx() x()",
+			want: concat(0x08, 0x01, 0x08, 0x01),
+		},
+		{
+			name: "macro calling macro",
+			text: "def x(n) = n: n\ndef y(n) = x(n) x(n)\ny(2)",
+			want: concat(0x10, 0x02, 0x10, 0x02),
+		},
+		{
+			name: "macro ends at newline",
+			text: "def x() = 1: 1 # {\n2: 2 x()",
+			want: concat(0x10, 0x02, 0x08, 0x01),
+		},
+		{
+			name: "macro wrong arity",
+			text: "def x(n) = n\nx(1, 2)",
+		},
+		{
+			name: "macro undefined",
+			text: "x(1)",
+		},
+		{
+			name: "macro redefined",
+			text: "def x() = 1\ndef x() = 2",
+		},
+		{
+			name: "macro recursive",
+			text: "def x() = y()\ndef y() = x()\nx()",
+		},
+		{
+			name: "macro unterminated call",
+			text: "def x(n) = n\nx(1",
+		},
+		{
+			name: "macro bad body",
+			text: "def x(n) = n: garbage\nx(1)",
+		},
+
 		{
 			name: "language.txt",
 			text: LanguageTxt,
 			want: concat(
 				"Quoted strings are delimited by double quotes. Backslash denotes escape\n",
-				"sequences. Legal escape sequences are: \\ \" \x00 \000 \n. \x00 consumes two\n",
-				"hex digits and emits a byte. \000 consumes one to three octal digits and emits\n",
-				"a byte (rejecting values that do not fit in a single octet). Otherwise, any\n",
-				"byte before the closing quote, including a newline, is emitted as-is.",
+				"sequences. Legal escape sequences are: \\ \" \x00 \000 \n \t \r \u0000\n",
+				"\U00000000. \x00 consumes two hex digits and emits a byte. \000 consumes one to\n",
+				"three octal digits and emits a byte (rejecting values that do not fit in a\n",
+				"single octet). \u0000 and \U00000000 consume four and eight hex digits,\n",
+				"respectively, and emit the UTF-8 encoding of that code point (rejecting\n",
+				"surrogates and values above \U0010FFFF). Otherwise, any byte before the closing\n",
+				"quote, including a newline, is emitted as-is.",
 
 				"hello world",
 				"hello world",
 
+				"a\nA'",
+
 				0x00, 0xab, 0xcd, 0xef, 0xab, 0xcd, 0xef,
+				0xde, 0xad, 0xbe, 0xef,
+
+				0x00, 0x01, 0x02, "hihi",
+
 				0xc8, 0x03,
 				0x81, 0x80, 0xfc, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+				0x0a,
 				0x03, 0x03,
 
 				0x00, 0x00, 0x00, 0x00,
 				0xe9, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0x03, 0x00, 0x00, 0x00,
 
 				0x83, 0x80, 0x80, 0x00,
 				0x83, 0x80, 0x80, 0x00,
 
+				0x88, 0x00, 0x82, 0x00, 0x03,
+				0x88, 0x00, 0x82, 0x00, 0x03,
+
 				0x01,
 				0x00,
 
 				num2le(1.0),
 				num2le(9.423e-2),
 				num2le(-0x1.ffp52),
+				num2le(0x1p-10),
 				num2le(float32(1.5)),
 				num2le(0xf.fp0),
 				num2le(float32(math.Inf(1))),
 				num2le(math.Inf(-1)),
+				0x00, 0x00, 0xc0, 0x7f,
 
 				0x08, 0x11, 0x1a, 0x23, 0x2c, 0x35,
 				0x80, 0x01,
 				0x46,
+				0x4d,
 
 				0x08, 55*2,
 				0x11, num2le(1.23),
@@ -733,6 +1315,22 @@ func TestScan(t *testing.T) {
 				0xdb, 0x01,
 				0xdc, 0x81, 0x80, 0x80, 0x00,
 
+				0xe3, 0x01,
+				0x08, 0x05,
+				0xe4, 0x01,
+
+				0xe2, 0x01, 0x07, 0x08, 0x00, 0x12, 0x03, "hdr",
+
+				0xea, 0x01, 0x04, 1, 1, 1, 1,
+				0xf0, 0x01, 0x01, 0xf0, 0x01, 0x01,
+				0xfa, 0x01, 0x06, "ababab",
+
+				0x82, 0x02, 0x08, "abc", 0, 0, 0, 0, 0,
+
+				0x26, 0x39, 0xf4, 0xcb, "123456789",
+
+				0x05, 0x07, 0x08, 0x02, 0x1a, 0x01, "x",
+
 				0x12, 0x04, "abcd",
 				0x12, 0x05, "abcd",
 				0x29, "stuff",
@@ -762,3 +1360,525 @@ func TestScan(t *testing.T) {
 		})
 	}
 }
+
+func TestPrettyContext(t *testing.T) {
+	tests := []struct {
+		name, text string
+		context    int
+		want       string
+	}{
+		{
+			name:    "first line",
+			text:    "garbage\n1: 2\n3: 4",
+			context: 1,
+			want: "1 | garbage\n" +
+				"  | ^\n" +
+				"2 | 1: 2",
+		},
+		{
+			name:    "middle",
+			text:    "1: 2\n3: 4\n5: oops\n6: 7\n8: 9\n10: 11",
+			context: 2,
+			want: "1 | 1: 2\n" +
+				"2 | 3: 4\n" +
+				"3 | 5: oops\n" +
+				"  |    ^\n" +
+				"4 | 6: 7\n" +
+				"5 | 8: 9",
+		},
+		{
+			name:    "tabs and wide runes",
+			text:    "\t\"施氏\" oops",
+			context: 2,
+			want: "1 | \t\"施氏\" oops\n" +
+				"  | \t       ^",
+		},
+		{
+			name:    "combining marks",
+			text:    "\"e\u0301\" oops",
+			context: 2,
+			want: "1 | \"e\u0301\" oops\n" +
+				"  |     ^",
+		},
+		{
+			name:    "line number width",
+			text:    "\n\n\n\n\n\n\n\n1: 2\noops",
+			context: 1,
+			want: " 9 | 1: 2\n" +
+				"10 | oops\n" +
+				"   | ^",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScanner(tt.text).Exec()
+			pe, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("expected a ParseError, got %v", err)
+			}
+
+			got := pe.PrettyContext(tt.text, tt.context)
+			if d := cmp.Diff(tt.want, got); d != "" {
+				t.Fatal("output mismatch (-want, +got):", d)
+			}
+		})
+	}
+}
+
+func TestScanFiles(t *testing.T) {
+	s := NewScanner("def f(x) = 1: x\nlong-form-default:1\n2: {")
+	s.SetFile("prologue.txt")
+	s.AddFile("body.txt", "f(3)\n")
+	s.AddFile("epilogue.txt", "}")
+
+	got, err := s.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x92, 0x00, 0x84, 0x00, 0x88, 0x00, 0x83, 0x00}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatal("output mismatch (-want, +got):", d)
+	}
+
+	s = NewScanner("1: {")
+	s.SetFile("prologue.txt")
+	s.AddFile("body.txt", "2: 3\n\n  oops")
+	_, err = s.Exec()
+	if err == nil || !strings.HasPrefix(err.Error(), "body.txt:3:3:") {
+		t.Fatalf("expected an error in body.txt, got %v", err)
+	}
+}
+
+func TestNext(t *testing.T) {
+	s := NewScanner("1: {\"a\"}\n  long-form:1 2 long-form:2 {} # done\n3:!{}")
+	var got []Token
+	for {
+		tok, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, tok)
+		if tok.Kind == TokenEOF {
+			break
+		}
+	}
+
+	want := []Token{
+		{Kind: TokenBytes, Value: []byte{0x08}, InferredType: true, FieldNumber: 1, Pos: Position{Offset: 0}},
+		{Kind: TokenLeftCurly, Length: -1, FieldNumber: -1, Pos: Position{Offset: 3, Column: 3}},
+		{Kind: TokenBytes, Value: []byte("a"), FieldNumber: -1, Pos: Position{Offset: 4, Column: 4}},
+		{Kind: TokenRightCurly, Length: -1, FieldNumber: -1, Pos: Position{Offset: 7, Column: 7}},
+		{Kind: TokenLongForm, Length: 1, FieldNumber: -1, Pos: Position{Offset: 11, Line: 1, Column: 2}},
+		{Kind: TokenBytes, Value: []byte{0x82, 0x00}, FieldNumber: -1, Pos: Position{Offset: 23, Line: 1, Column: 14}},
+		{Kind: TokenLongForm, Length: 2, FieldNumber: -1, Pos: Position{Offset: 25, Line: 1, Column: 16}},
+		{Kind: TokenLeftCurly, Length: 2, FieldNumber: -1, Pos: Position{Offset: 37, Line: 1, Column: 28}},
+		{Kind: TokenRightCurly, Length: -1, FieldNumber: -1, Pos: Position{Offset: 38, Line: 1, Column: 29}},
+		{Kind: TokenBytes, Value: []byte{0x18}, InferredType: true, FieldNumber: 3, Pos: Position{Offset: 47, Line: 2}},
+		{Kind: TokenGroupCurly, FieldNumber: -1, Pos: Position{Offset: 49, Line: 2, Column: 2}},
+		{Kind: TokenRightCurly, Length: -1, FieldNumber: -1, Pos: Position{Offset: 51, Line: 2, Column: 4}},
+		{Kind: TokenEOF, FieldNumber: -1, Pos: Position{Offset: 52, Line: 2, Column: 5}},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatal("token mismatch (-want, +got):", d)
+	}
+
+	s = NewScanner("long-form:1 1i32")
+	if _, err := s.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Next(); err == nil {
+		t.Fatal("expected an error for a dangling length modifier")
+	}
+}
+
+func TestExecAll(t *testing.T) {
+	tests := []struct {
+		name, text string
+		// want is the list of errors, as strings; if it is empty, scanning
+		// should succeed.
+		want []string
+	}{
+		{
+			name: "valid",
+			text: "1: {2: 3}",
+		},
+		{
+			name: "bad tokens",
+			text: "1: oops\n2: {3: 1.2.3}\n4: 5",
+			want: []string{
+				`<input>:1:4: unrecognized symbol "oops"`,
+				`<input>:2:8: unrecognized symbol "1.2.3"`,
+			},
+		},
+		{
+			name: "stray braces",
+			text: "}\n1: {\n}}\n2: {",
+			want: []string{
+				`<input>:1:1: unmatched '}'`,
+				`<input>:3:2: unmatched '}'`,
+				`<input>:4:4: unmatched '{'`,
+			},
+		},
+		{
+			name: "bad group",
+			text: "1: { !{ 2: 3 } }\nbad",
+			want: []string{
+				`<input>:1:6: group !{} must immediately follow untyped field number`,
+				`<input>:2:1: unrecognized symbol "bad"`,
+			},
+		},
+		{
+			name: "unterminated literals",
+			text: "1: 'ab' 2: `00\n3: 4}",
+			want: []string{
+				`<input>:1:4: character literal must contain exactly one character`,
+				"<input>:1:13: unmatched `",
+			},
+		},
+		{
+			name: "macro",
+			text: "def f() = 1: oops 2: oops\nf() f()",
+			want: []string{
+				`<input>:2:1: in expansion of macro "f" (defined at <input>:1:1): unrecognized symbol "oops"`,
+				`<input>:2:5: in expansion of macro "f" (defined at <input>:1:1): unrecognized symbol "oops"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, errs := NewScanner(tt.text).ExecAll()
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			if d := cmp.Diff(tt.want, got); d != "" {
+				t.Fatal("errors mismatch (-want, +got):", d)
+			}
+			if len(errs) != 0 && out != nil {
+				t.Fatalf("got output %x despite errors", out)
+			}
+		})
+	}
+}
+
+func TestExecContext(t *testing.T) {
+	got, err := NewScanner("1: {2: 3}").ExecContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]byte{0x0a, 0x02, 0x10, 0x03}, got); d != "" {
+		t.Fatal("output mismatch (-want, +got):", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	deep := strings.Repeat("{", 10000) + strings.Repeat("}", 10000)
+	if _, err := NewScanner(deep).ExecContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	deep := strings.Repeat("1: {", DefaultMaxDepth+1) + strings.Repeat("}", DefaultMaxDepth+1)
+	_, err := NewScanner(deep).Exec()
+	want := fmt.Sprintf("<input>:1:%d: maximum nesting depth exceeded", 4*DefaultMaxDepth+4)
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+
+	s := NewScanner("{{{}}} {{{{}}}}")
+	s.MaxDepth = 3
+	if _, err := s.Exec(); err == nil || !strings.HasSuffix(err.Error(), "1:11: maximum nesting depth exceeded") {
+		t.Fatalf("expected an error at the fourth {, got %v", err)
+	}
+
+	s = NewScanner("{{{{}}}}")
+	s.MaxDepth = 0
+	if _, err := s.Exec(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chunkWriter records each call to Write.
+type chunkWriter struct {
+	chunks [][]byte
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	w.chunks = append(w.chunks, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestExecTo(t *testing.T) {
+	// Each line is a field whose type is only known once the next token is
+	// read, which should not be written too early.
+	text := strings.Repeat("1: 2 3: {4: 5} 6:\n7\n", 2000)
+	want, err := NewScanner(text).Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := new(chunkWriter)
+	if err := NewScanner(text).ExecTo(w); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.chunks) < 2 {
+		t.Errorf("expected output in several chunks, got %d", len(w.chunks))
+	}
+	if d := cmp.Diff(want, bytes.Join(w.chunks, nil)); d != "" {
+		t.Fatal("output mismatch (-want, +got):", d)
+	}
+
+	// fill:N counts the output that has already been written.
+	w = new(chunkWriter)
+	if err := NewScanner(text + "fill:100000 0").ExecTo(w); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(bytes.Join(w.chunks, nil)); got != 100000 {
+		t.Errorf("got %d bytes of output after fill:100000, want 100000", got)
+	}
+
+	// Nothing may be written while a len(name) is waiting for its label.
+	labeled := "len(a) " + text + `@a{"xyz"}`
+	want, err = NewScanner(labeled).Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = new(chunkWriter)
+	if err := NewScanner(labeled).ExecTo(w); err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(want, bytes.Join(w.chunks, nil)); d != "" {
+		t.Fatal("output mismatch (-want, +got):", d)
+	}
+
+	if err := NewScanner(text + "}").ExecTo(io.Discard); err == nil {
+		t.Fatal("expected an error but didn't get one")
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := NewScanner("def x(n) = n: 2\nx(1)")
+	s.SetFile("a.txt")
+	s.AddFile("b.txt", "x(3)")
+	if _, err := s.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the macro nor the queued file should survive.
+	s.Reset("x(1)")
+	_, err := s.Exec()
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a ParseError, got %v", err)
+	}
+	if pe.Pos.File != "a.txt" {
+		t.Errorf("got error in %q, want a.txt", pe.Pos.File)
+	}
+
+	s.Reset("1: {2: 3}")
+	got, err := s.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x0a, 0x02, 0x10, 0x03}; !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	tests := []struct {
+		name, text string
+		// want is the same input with field numbers; if empty, expects scanning
+		// to fail.
+		want string
+	}{
+		{
+			name: "scalars",
+			text: `optional_int32: 1 optional_string: {"hi"} optional_double: 1.5`,
+			want: `1: 1 14: {"hi"} 12: 1.5`,
+		},
+		{
+			name: "explicit type",
+			text: `optional_int32:I32 5i32`,
+			want: `1:I32 5i32`,
+		},
+		{
+			name: "long form",
+			text: `long-form:2 optional_int32: 1`,
+			want: `long-form:2 1: 1`,
+		},
+		{
+			name: "nested",
+			text: `optional_nested_message: {bb: 5} repeated_foreign_message: {c: 6}`,
+			want: `18: {1: 5} 49: {1: 6}`,
+		},
+		{
+			name: "numbered nested",
+			text: `18: {bb: 5}`,
+			want: `18: {1: 5}`,
+		},
+		{
+			name: "group",
+			text: `optionalgroup: !{a: 1} optional_int32: 2`,
+			want: `16: !{17: 1} 1: 2`,
+		},
+		{
+			name: "mixed",
+			text: `1: 2 optional_nested_message: {1: 3 bb: 4}`,
+			want: `1: 2 18: {1: 3 1: 4}`,
+		},
+		{
+			name: "no such field",
+			text: `optional_int33: 1`,
+		},
+		{
+			name: "unknown block",
+			text: `optional_int32: {bb: 5}`,
+		},
+		{
+			name: "not a tag's block",
+			text: `optional_int32: 1 {bb: 5}`,
+		},
+		{
+			name: "field of the outer message",
+			text: `optional_nested_message: {optional_int32: 5}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner(tt.text)
+			s.Schema = GetDesc("unittest.TestAllTypes")
+			got, err := s.Exec()
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("expected an error but got %x", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := NewScanner(tt.want).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(want, got); d != "" {
+				t.Fatal("output mismatch (-want, +got):", d)
+			}
+		})
+	}
+
+	if _, err := NewScanner("optional_int32: 1").Exec(); err == nil {
+		t.Error("expected an error for a field name without a schema")
+	}
+}
+
+func TestStrict(t *testing.T) {
+	tests := []struct {
+		name, text string
+		// If wantErr is empty, expects scanning to succeed.
+		wantErr string
+	}{
+		{name: "canonical", text: `1: 5 2: {3: -1 4: 1.5} 5: 6i32`},
+		{name: "long-form:0", text: `1: long-form:0 5`, wantErr: "1:4: long-form:0 is not allowed in strict mode"},
+		{name: "long-form varint", text: `1: long-form:2 5`, wantErr: "1:4: long-form:2 is not allowed"},
+		{name: "long-form length", text: `1: long-form:1 {}`, wantErr: "1:4: long-form:1 is not allowed"},
+		{name: "long-form-default", text: "long-form-default:1\n1: 5", wantErr: "1:1: long-form-default:1 is not allowed"},
+		{name: "group syntax", text: `1: !{2: 3}`, wantErr: "1:4: groups are not allowed"},
+		{name: "SGROUP", text: `1:SGROUP 1:EGROUP`, wantErr: "1:1: group tags are not allowed"},
+		{name: "EGROUP", text: `1: 2 3:4`, wantErr: "1:6: group tags are not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner(tt.text)
+			s.Strict = true
+			_, err := s.Exec()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected %q, got %v", tt.wantErr, err)
+			}
+
+			// Everything is allowed by default.
+			if _, err := NewScanner(tt.text).Exec(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	got, err := Assemble(`1: {"hi"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x0a, 0x02, 'h', 'i'}; !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	_, err = AssembleFile("test.pb.txt", "1: {")
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("got error %v, want a ParseError", err)
+	}
+	if pe.Pos.File != "test.pb.txt" {
+		t.Errorf("got error in file %q, want %q", pe.Pos.File, "test.pb.txt")
+	}
+}
+
+var benchInput = "def f(n) = n: {\"hello\"}\n" +
+	strings.Repeat("1: 2 3: {4: 5 f(6)} 7: 8.5\n", 10)
+
+func BenchmarkNewScanner(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewScanner(benchInput).Exec(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReset(b *testing.B) {
+	b.ReportAllocs()
+	s := NewScanner("")
+	for i := 0; i < b.N; i++ {
+		s.Reset(benchInput)
+		if _, err := s.Exec(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeVarint(b *testing.B) {
+	// A spread of varint lengths, some of them in long form.
+	values := []struct {
+		value    uint64
+		longForm int
+	}{{1, 0}, {300, 0}, {1 << 35, 0}, {math.MaxUint64, 0}, {5, 3}, {1 << 20, 6}}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, v := range values {
+			_ = encodeVarint(nil, v.value, v.longForm)
+		}
+	}
+}
+
+func BenchmarkManyVarints(b *testing.B) {
+	text := strings.Repeat("1: 150 2: 1000000 3: -1 4: long-form:2 7\n", 1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewScanner(text).Exec(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import "slices"
+
+// A SourceSpan records which token produced a range of assembled output.
+type SourceSpan struct {
+	// Start and End are the byte offsets of the range in the output, which
+	// includes Start but not End.
+	Start, End int
+	// Pos is the position of the token that produced the range. Length
+	// prefixes are attributed to their {, checksums to their crc32{, and
+	// end-group tags to their }.
+	Pos Position
+	// Comment is that token's Comment, if Scanner.KeepComments is set.
+	Comment string
+}
+
+// ExecWithSourceMap is like Exec, but also returns a SourceSpan for each token
+// that contributed bytes to the output, in order of offset.
+func (s *Scanner) ExecWithSourceMap() ([]byte, []SourceSpan, error) {
+	s.recordSpans = true
+	s.spans = nil
+	defer func() {
+		s.recordSpans = false
+		s.spans = nil
+	}()
+
+	out, err := s.exec(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, s.spans, nil
+}
+
+// addSpan records that out[start:end] was produced by token, if a source map
+// is being recorded.
+func (s *Scanner) addSpan(start, end int, token Token) {
+	if s.recordSpans && start != end {
+		s.spans = append(s.spans, SourceSpan{start, end, token.Pos, token.Comment})
+	}
+}
+
+// nestSpans fixes up the spans recorded by a nested call to exec, starting at
+// index first, once its output has been appended after a length prefix that
+// begins at start and ends at offset. The prefix is attributed to token.
+func (s *Scanner) nestSpans(first, start, offset int, token Token) {
+	if !s.recordSpans {
+		return
+	}
+	child := append([]SourceSpan(nil), s.spans[first:]...)
+	s.spans = s.spans[:first]
+	s.addSpan(start, offset, token)
+	for _, span := range child {
+		span.Start += offset
+		span.End += offset
+		s.spans = append(s.spans, span)
+	}
+}
+
+// shiftSpans moves the spans recorded since index first, which are relative to
+// the start of some bytes, to where those bytes begin at offset in the output.
+func (s *Scanner) shiftSpans(first, offset int) {
+	if !s.recordSpans {
+		return
+	}
+	for i := range s.spans[first:] {
+		s.spans[first+i].Start += offset
+		s.spans[first+i].End += offset
+	}
+}
+
+// repeatSpans fixes up the spans recorded since index first, which cover a
+// value of size bytes, once that value has been repeated count times.
+func (s *Scanner) repeatSpans(first, size, count int) {
+	if !s.recordSpans {
+		return
+	}
+	once := append([]SourceSpan(nil), s.spans[first:]...)
+	s.spans = s.spans[:first]
+	if len(once) == 0 {
+		return
+	}
+	for i := 0; i < count; i++ {
+		for _, span := range once {
+			span.Start += i * size
+			span.End += i * size
+			s.spans = append(s.spans, span)
+		}
+	}
+}
+
+// insertSpan fixes up the spans recorded since index first, which are relative
+// to the start of some bytes, once size bytes produced by token have been
+// inserted into them at offset.
+func (s *Scanner) insertSpan(first, offset, size int, token Token) {
+	if !s.recordSpans || size == 0 {
+		return
+	}
+	i := first
+	for i < len(s.spans) && s.spans[i].Start < offset {
+		i++
+	}
+	for j := range s.spans[i:] {
+		s.spans[i+j].Start += size
+		s.spans[i+j].End += size
+	}
+	s.spans = slices.Insert(s.spans, i, SourceSpan{offset, offset + size, token.Pos, token.Comment})
+}
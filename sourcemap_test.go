@@ -0,0 +1,183 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExecWithSourceMap(t *testing.T) {
+	s := NewScanner("1: 5\n2: {3: {\"hi\"}}\n4: !{}")
+	out, spans, err := s.ExecWithSourceMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantOut := []byte{0x08, 0x05, 0x12, 0x04, 0x1a, 0x02, 'h', 'i', 0x23, 0x24}
+	if d := cmp.Diff(wantOut, out); d != "" {
+		t.Fatal("output mismatch (-want, +got):", d)
+	}
+
+	pos := func(line, col int) Position {
+		offsets := []int{0, 5, 20}
+		return Position{Offset: offsets[line] + col, Line: line, Column: col}
+	}
+	wantSpans := []SourceSpan{
+		{0, 1, pos(0, 0), ""},  // 1:
+		{1, 2, pos(0, 3), ""},  // 5
+		{2, 3, pos(1, 0), ""},  // 2:
+		{3, 4, pos(1, 3), ""},  // {
+		{4, 5, pos(1, 4), ""},  // 3:
+		{5, 6, pos(1, 7), ""},  // {
+		{6, 8, pos(1, 8), ""},  // "hi"
+		{8, 9, pos(2, 0), ""},  // 4:
+		{9, 10, pos(2, 5), ""}, // }
+	}
+	if d := cmp.Diff(wantSpans, spans); d != "" {
+		t.Fatal("source map mismatch (-want, +got):", d)
+	}
+
+	s = NewScanner("1: {")
+	if _, spans, err := s.ExecWithSourceMap(); err == nil || spans != nil {
+		t.Fatalf("expected an error and no spans, got %v, %v", spans, err)
+	}
+}
+
+func TestRepeatSourceMap(t *testing.T) {
+	s := NewScanner("0 repeat:2 {1: 5} 9")
+	out, spans, err := s.ExecWithSourceMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantOut := []byte{0x00, 0x08, 0x05, 0x08, 0x05, 0x09}
+	if d := cmp.Diff(wantOut, out); d != "" {
+		t.Fatal("output mismatch (-want, +got):", d)
+	}
+
+	pos := func(col int) Position {
+		return Position{Offset: col, Column: col}
+	}
+	wantSpans := []SourceSpan{
+		{0, 1, pos(0), ""},  // 0
+		{1, 2, pos(12), ""}, // 1:
+		{2, 3, pos(15), ""}, // 5
+		{3, 4, pos(12), ""}, // 1:
+		{4, 5, pos(15), ""}, // 5
+		{5, 6, pos(18), ""}, // 9
+	}
+	if d := cmp.Diff(wantSpans, spans); d != "" {
+		t.Fatal("source map mismatch (-want, +got):", d)
+	}
+}
+
+func TestLabelSourceMap(t *testing.T) {
+	s := NewScanner(`1 len(a) len(a) @a{"xy"} 3`)
+	out, spans, err := s.ExecWithSourceMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantOut := []byte{0x01, 0x02, 0x02, 'x', 'y', 0x03}
+	if d := cmp.Diff(wantOut, out); d != "" {
+		t.Fatal("output mismatch (-want, +got):", d)
+	}
+
+	pos := func(col int) Position {
+		return Position{Offset: col, Column: col}
+	}
+	wantSpans := []SourceSpan{
+		{0, 1, pos(0), ""},  // 1
+		{1, 2, pos(2), ""},  // len(a)
+		{2, 3, pos(9), ""},  // len(a)
+		{3, 5, pos(19), ""}, // "xy"
+		{5, 6, pos(25), ""}, // 3
+	}
+	if d := cmp.Diff(wantSpans, spans); d != "" {
+		t.Fatal("source map mismatch (-want, +got):", d)
+	}
+}
+
+func TestKeepComments(t *testing.T) {
+	text := "1: 5 # five\n# on its own\n2: { # nested\n  3: 4  #\tfour \n} # end\n6: 7"
+	s := NewScanner(text)
+	s.KeepComments = true
+	out, spans, err := s.ExecWithSourceMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := NewScanner(text).Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(want, out); d != "" {
+		t.Fatal("output mismatch (-want, +got):", d)
+	}
+
+	var got []string
+	for _, span := range spans {
+		got = append(got, span.Comment)
+	}
+	// The } that ends the length-prefixed field produces no bytes of its own,
+	// so its comment has no span.
+	wantComments := []string{"", "five", "", "nested", "", "four", "", ""}
+	if d := cmp.Diff(wantComments, got); d != "" {
+		t.Fatal("comment mismatch (-want, +got):", d)
+	}
+
+	s = NewScanner(text)
+	s.KeepComments = true
+	got = nil
+	for {
+		tok, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Kind == TokenEOF {
+			break
+		}
+		got = append(got, tok.Comment)
+	}
+	wantComments = []string{"", "five", "", "nested", "", "four", "end", "", ""}
+	if d := cmp.Diff(wantComments, got); d != "" {
+		t.Fatal("token comment mismatch (-want, +got):", d)
+	}
+}
+
+func TestKeepCommentsSeparators(t *testing.T) {
+	// Commas and semicolons are whitespace, so they may come between a token
+	// and its comment.
+	text := "1: 2, # two\n3: 4 ; # four\n5: 6,\n# own line\n7: 8"
+	s := NewScanner(text)
+	s.KeepComments = true
+	var got []string
+	for {
+		tok, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.Kind == TokenEOF {
+			break
+		}
+		got = append(got, tok.Comment)
+	}
+	want := []string{"", "two", "", "four", "", "", "", ""}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Fatal("token comment mismatch (-want, +got):", d)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stats is a structural profile of an encoded message, as computed by
+// ComputeStats.
+type Stats struct {
+	// MaxDepth is the deepest level of nesting at which a field was found. Top
+	// level fields are at depth zero.
+	MaxDepth int
+	// FieldsPerLevel is the number of fields found at each depth.
+	FieldsPerLevel []int
+
+	// Largest describes the field with the longest encoding, including its tag.
+	Largest FieldStat
+
+	// The number of length-prefixed fields classified as messages, strings,
+	// and opaque bytes, respectively.
+	Messages, Strings, Bytes int
+
+	// DepthCapped is set if some message was not descended into because it
+	// was nested deeper than the cap passed to ComputeStats.
+	DepthCapped bool
+	// Trailing is the number of bytes at the end of the input that could not
+	// be parsed as fields.
+	Trailing int
+}
+
+// FieldStat describes a single field seen while computing Stats.
+type FieldStat struct {
+	Number uint64
+	Depth  int
+	Offset int // Offset of the tag from the start of the input.
+	Size   int // Size of the field, including its tag.
+}
+
+// ComputeStats walks src as an encoded message, descending into
+// length-prefixed fields that parse as messages, and records statistics about
+// its structure.
+//
+// Messages nested more than maxDepth levels deep are counted, but not
+// descended into. A negative maxDepth means there is no cap.
+func ComputeStats(src []byte, maxDepth int) Stats {
+	var st Stats
+	rest := st.walk(src, 0, 0, maxDepth)
+	st.Trailing = len(rest)
+	return st
+}
+
+// walk records every field it can parse out of src, returning whatever could
+// not be parsed. offset is the offset of src within the original input.
+func (st *Stats) walk(src []byte, offset, depth, maxDepth int) []byte {
+	start := len(src)
+	groups := 0
+	for len(src) > 0 {
+		fieldStart := offset + start - len(src)
+		rest, tag, _, ok := decodeVarint(src)
+		if !ok || tag>>3 == 0 {
+			break
+		}
+
+		number := tag >> 3
+		fieldDepth := depth + groups
+		var delimited []byte
+		switch tag & 0x7 {
+		case 0:
+			rest, _, _, ok = decodeVarint(rest)
+		case 1:
+			ok = len(rest) >= 8
+			if ok {
+				rest = rest[8:]
+			}
+		case 5:
+			ok = len(rest) >= 4
+			if ok {
+				rest = rest[4:]
+			}
+		case 2:
+			var n uint64
+			rest, n, _, ok = decodeVarint(rest)
+			ok = ok && uint64(len(rest)) >= n
+			if ok {
+				delimited, rest = rest[:n], rest[n:]
+			}
+		case 3:
+			groups++
+		case 4:
+			// EGROUP tags close a group rather than begin a new field.
+			if groups > 0 {
+				groups--
+				src = rest
+				continue
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			break
+		}
+
+		if fieldDepth > st.MaxDepth {
+			st.MaxDepth = fieldDepth
+		}
+		for len(st.FieldsPerLevel) <= fieldDepth {
+			st.FieldsPerLevel = append(st.FieldsPerLevel, 0)
+		}
+		st.FieldsPerLevel[fieldDepth]++
+
+		size := len(src) - len(rest)
+		if size > st.Largest.Size {
+			st.Largest = FieldStat{number, fieldDepth, fieldStart, size}
+		}
+
+		if tag&0x7 == 2 {
+			switch {
+			case isMessage(delimited):
+				st.Messages++
+				if maxDepth >= 0 && fieldDepth+1 > maxDepth {
+					st.DepthCapped = true
+					break
+				}
+				st.walk(delimited, fieldStart+size-len(delimited), fieldDepth+1, maxDepth)
+			case looksLikeString(delimited, defaultStringThreshold):
+				st.Strings++
+			default:
+				st.Bytes++
+			}
+		}
+
+		src = rest
+	}
+	return src
+}
+
+// isMessage returns whether src consists entirely of well-formed fields.
+func isMessage(src []byte) bool {
+	var st Stats
+	return len(st.walk(src, 0, 0, 0)) == 0
+}
+
+// String renders these statistics as a block of Protoscope comments.
+func (st Stats) String() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "# stats:")
+	fmt.Fprintf(&b, "#   max depth: %d\n", st.MaxDepth)
+	for depth, n := range st.FieldsPerLevel {
+		fmt.Fprintf(&b, "#   fields at depth %d: %d\n", depth, n)
+	}
+	if st.Largest.Size > 0 {
+		fmt.Fprintf(&b, "#   largest field: %d at offset %#x (depth %d, %d bytes)\n",
+			st.Largest.Number, st.Largest.Offset, st.Largest.Depth, st.Largest.Size)
+	}
+	fmt.Fprintf(&b, "#   messages: %d\n", st.Messages)
+	fmt.Fprintf(&b, "#   strings: %d\n", st.Strings)
+	fmt.Fprintf(&b, "#   bytes: %d\n", st.Bytes)
+	if st.DepthCapped {
+		fmt.Fprintln(&b, "#   (some messages were nested too deeply to descend into)")
+	}
+	if st.Trailing > 0 {
+		fmt.Fprintf(&b, "#   unparsed trailing bytes: %d\n", st.Trailing)
+	}
+	return b.String()
+}
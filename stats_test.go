@@ -0,0 +1,104 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestComputeStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		maxDepth int
+		want     Stats
+	}{
+		{
+			name:     "empty",
+			maxDepth: -1,
+			want:     Stats{},
+		},
+		{
+			name:     "flat",
+			text:     `1: 5 2: {"hello"} 3: {` + "`ff00ff`" + `}`,
+			maxDepth: -1,
+			want: Stats{
+				FieldsPerLevel: []int{3},
+				Largest:        FieldStat{Number: 2, Offset: 2, Size: 7},
+				Strings:        1,
+				Bytes:          1,
+			},
+		},
+		{
+			name:     "nested",
+			text:     `1: { 2: { 3: 4 } 5: 6 } 7: 8`,
+			maxDepth: -1,
+			want: Stats{
+				MaxDepth:       2,
+				FieldsPerLevel: []int{2, 2, 1},
+				Largest:        FieldStat{Number: 1, Offset: 0, Size: 8},
+				Messages:       2,
+			},
+		},
+		{
+			name:     "depth cap",
+			text:     `1: { 2: { 3: 4 } 5: 6 } 7: 8`,
+			maxDepth: 1,
+			want: Stats{
+				MaxDepth:       1,
+				FieldsPerLevel: []int{2, 2},
+				Largest:        FieldStat{Number: 1, Offset: 0, Size: 8},
+				Messages:       2,
+				DepthCapped:    true,
+			},
+		},
+		{
+			name:     "groups",
+			text:     `1: !{ 2: 3 }`,
+			maxDepth: -1,
+			want: Stats{
+				MaxDepth:       1,
+				FieldsPerLevel: []int{1, 1},
+				Largest:        FieldStat{Number: 2, Offset: 1, Depth: 1, Size: 2},
+			},
+		},
+		{
+			name:     "trailing garbage",
+			text:     "1: 2 `0000`",
+			maxDepth: -1,
+			want: Stats{
+				FieldsPerLevel: []int{1},
+				Largest:        FieldStat{Number: 1, Size: 2},
+				Trailing:       2,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ComputeStats(src, tt.maxDepth)
+			if d := cmp.Diff(tt.want, got); d != "" {
+				t.Fatal("stats mismatch (-want, +got):", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+
+
+aliases.protoaliases"
+Palette(
+primary (2.aliases.ColorRprimary&
+colors (2.aliases.ColorRcolors7
+packed_colors (2.aliases.ColorBRpackedColors*P
+Color
+RED	
+GREEN
+CRIMSON
+BLUE
+SCARLET
+EMERALD
\ No newline at end of file
@@ -0,0 +1,3 @@
+
+bob+
+#type.googleapis.com/anytest.Missing
\ No newline at end of file
@@ -0,0 +1,5 @@
+
+alice\
+$type.googleapis.com/anytest.Greeting4
+hello)
+!type.googleapis.com/anytest.Point
\ No newline at end of file
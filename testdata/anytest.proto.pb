@@ -0,0 +1,19 @@
+
+
+google/protobuf/any.protogoogle.protobuf"6
+Any
+type_url (	RtypeUrl
+value (RvalueBv
+com.google.protobufBAnyProtoPZ,google.golang.org/protobuf/types/known/anypbGPBGoogle.Protobuf.WellKnownTypesbproto3
+
+anytest.protoanytestgoogle/protobuf/any.proto"R
+Envelope
+sender (	Rsender.
+payload (2.google.protobuf.AnyRpayload"`
+Greeting
+text (	Rtext
+count (Rcount*
+inner (2.google.protobuf.AnyRinner"#
+Point
+x (Rx
+y (Rybproto3
\ No newline at end of file
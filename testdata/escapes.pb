@@ -0,0 +1,3 @@
+
+name	value
+	col1	col2
\ No newline at end of file
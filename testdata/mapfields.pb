@@ -0,0 +1,11 @@
+
+	
+apple
+
+
+banana
+
+cherry
+
+anvil	
+feather
\ No newline at end of file
@@ -0,0 +1,16 @@
+
+
+mapfields.proto	mapfields"2
+Item
+name (	Rname
+weight (Rweight"
+	Inventory8
+counts (2 .mapfields.Inventory.CountsEntryRcounts5
+items (2.mapfields.Inventory.ItemsEntryRitems9
+CountsEntry
+key (	Rkey
+value (Rvalue:8I
+
+ItemsEntry
+key (Rkey%
+value (2.mapfields.ItemRvalue:8bproto3
\ No newline at end of file
@@ -0,0 +1,3 @@
+
+
+"deep*	2shallow:@	
\ No newline at end of file
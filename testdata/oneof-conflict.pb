@@ -0,0 +1 @@
+hiagain
\ No newline at end of file
@@ -0,0 +1 @@
+c
\ No newline at end of file
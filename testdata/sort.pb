@@ -0,0 +1 @@
+(bHHa#80$
\ No newline at end of file
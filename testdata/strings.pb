@@ -0,0 +1,3 @@
+
+hello
+abcdefghiab"a
\ No newline at end of file
@@ -0,0 +1,4 @@
+
+Ϫ:Zʵ*"
+hello*2:
+ֹ
\ No newline at end of file
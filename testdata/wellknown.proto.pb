@@ -0,0 +1,48 @@
+
+
+google/protobuf/duration.protogoogle.protobuf":
+Duration
+seconds (Rseconds
+nanos (RnanosB
+com.google.protobufBDurationProtoPZ1google.golang.org/protobuf/types/known/durationpbGPBGoogle.Protobuf.WellKnownTypesbproto3
+
+google/protobuf/timestamp.protogoogle.protobuf";
+	Timestamp
+seconds (Rseconds
+nanos (RnanosB
+com.google.protobufBTimestampProtoPZ2google.golang.org/protobuf/types/known/timestamppbGPBGoogle.Protobuf.WellKnownTypesbproto3
+
+google/protobuf/wrappers.protogoogle.protobuf"#
+DoubleValue
+value (Rvalue""
+
+FloatValue
+value (Rvalue""
+
+Int64Value
+value (Rvalue"#
+UInt64Value
+value (Rvalue""
+
+Int32Value
+value (Rvalue"#
+UInt32Value
+value (Rvalue"!
+	BoolValue
+value (Rvalue"#
+StringValue
+value (	Rvalue""
+
+BytesValue
+value (RvalueB
+com.google.protobufBWrappersProtoPZ1google.golang.org/protobuf/types/known/wrapperspbGPBGoogle.Protobuf.WellKnownTypesbproto3
+
+wellknown.proto	wellknowngoogle/protobuf/duration.protogoogle/protobuf/timestamp.protogoogle/protobuf/wrappers.proto"
+Event.
+time (2.google.protobuf.TimestampRtime3
+elapsed (2.google.protobuf.DurationRelapsed1
+count (2.google.protobuf.Int32ValueRcount2
+label (2.google.protobuf.StringValueRlabel.
+flag (2.google.protobuf.BoolValueRflag3
+backoff (2.google.protobuf.DurationRbackoff,
+odd (2.google.protobuf.TimestampRoddbproto3
\ No newline at end of file
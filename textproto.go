@@ -0,0 +1,95 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// WriteTextProto disassembles src, an encoded message of type schema, into the
+// standard protobuf text format rather than Protoscope.
+//
+// Fields that schema does not know about are printed as Protoscope in comments
+// after the message, one block per message that has any. If src cannot be
+// parsed as a schema at all, an error is returned.
+func WriteTextProto(src []byte, schema protoreflect.MessageDescriptor) (string, error) {
+	msg := dynamicpb.NewMessage(schema)
+	if err := proto.Unmarshal(src, msg); err != nil {
+		return "", err
+	}
+
+	text, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	out.Write(text)
+	writeUnknown(&out, msg, string(schema.FullName()))
+	return out.String(), nil
+}
+
+// writeUnknown appends the unknown fields of m and of every message within it
+// to out as comments. path names m in those comments.
+func writeUnknown(out *strings.Builder, m protoreflect.Message, path string) {
+	if u := m.GetUnknown(); len(u) > 0 {
+		fmt.Fprintf(out, "# unknown fields in %s:\n", path)
+		out.WriteString(Write(u, WriterOptions{CommentEverything: true}))
+	}
+
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !m.Has(fd) {
+			continue
+		}
+		v := m.Get(fd)
+		name := path + "." + fd.TextName()
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				break
+			}
+			// Map iteration order is random, so go in order of the keys.
+			var keys []protoreflect.MapKey
+			v.Map().Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+				keys = append(keys, k)
+				return true
+			})
+			sort.Slice(keys, func(i, j int) bool {
+				return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+			})
+			for _, k := range keys {
+				writeUnknown(out, v.Map().Get(k).Message(), fmt.Sprintf("%s[%v]", name, k.Interface()))
+			}
+		case fd.IsList():
+			if fd.Message() == nil {
+				break
+			}
+			for i := 0; i < v.List().Len(); i++ {
+				writeUnknown(out, v.List().Get(i).Message(), fmt.Sprintf("%s[%d]", name, i))
+			}
+		case fd.Message() != nil:
+			writeUnknown(out, v.Message(), name)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protoscope
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestWriteTextProto(t *testing.T) {
+	schema := GetDesc("mapfields.Inventory")
+	tests := []struct {
+		name, text string
+		// want is the text format of the known fields; unknown is the comment
+		// that the unknown fields should produce, if any.
+		want, unknown string
+	}{
+		{
+			name: "known",
+			text: `1: {1: {"apple"} 2: 3} 2: {1: 7 2: {1: {"pear"} 2: 5}}`,
+			want: `counts { key: "apple" value: 3 } items { key: 7 value { name: "pear" weight: 5 } }`,
+		},
+		{
+			name:    "unknown top-level",
+			text:    `1: {1: {"apple"} 2: 3} 9: 10`,
+			want:    `counts { key: "apple" value: 3 }`,
+			unknown: "# unknown fields in mapfields.Inventory:\n# 9: 10\n",
+		},
+		{
+			name:    "unknown nested",
+			text:    `2: {1: 7 2: {1: {"pear"} 3: 4i32}}`,
+			want:    `items { key: 7 value { name: "pear" } }`,
+			unknown: "# unknown fields in mapfields.Inventory.items[7]:\n# 3: 4i32\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := WriteTextProto(src, schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The exact spacing of prototext is deliberately unstable, so compare
+			// the messages the text describes instead.
+			gotMsg := dynamicpb.NewMessage(schema)
+			if err := prototext.Unmarshal([]byte(got), gotMsg); err != nil {
+				t.Fatalf("output is not valid text format: %s\n%s", err, got)
+			}
+			wantMsg := dynamicpb.NewMessage(schema)
+			if err := prototext.Unmarshal([]byte(tt.want), wantMsg); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(gotMsg, wantMsg) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+
+			if tt.unknown == "" && strings.Contains(got, "unknown fields") {
+				t.Errorf("unexpected unknown fields in %q", got)
+			}
+			if !strings.Contains(got, tt.unknown) {
+				t.Errorf("got %q, want it to contain %q", got, tt.unknown)
+			}
+		})
+	}
+}
+
+func TestWriteTextProtoError(t *testing.T) {
+	// A length prefix that runs off the end.
+	if _, err := WriteTextProto([]byte{0x0a, 0x05, 0x0a}, GetDesc("mapfields.Inventory")); err == nil {
+		t.Error("want error for truncated input")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wire contains primitives for encoding and decoding the protobuf wire
+// format that, unlike those in google.golang.org/protobuf/encoding/protowire,
+// can represent non-minimal encodings.
+package wire
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// AppendVarint appends v to dst as a varint, followed by longForm extra bytes
+// of padding, and returns the extended slice.
+//
+// Unlike protowire.AppendVarint, this can produce non-minimal varints: the
+// padding consists of bytes that contribute nothing to the value.
+func AppendVarint(dst []byte, v uint64, longForm int) []byte {
+	// Reserve space for the longest possible varint up front, so that dst is
+	// grown at most once.
+	dst = slices.Grow(dst, binary.MaxVarintLen64+longForm)
+	for v > 0x7f {
+		dst = append(dst, byte(v&0x7f)|0x80)
+		v >>= 7
+	}
+	dst = append(dst, byte(v))
+
+	if longForm > 0 {
+		dst[len(dst)-1] |= 0x80
+		for longForm > 1 {
+			dst = append(dst, 0x80)
+			longForm--
+		}
+		dst = append(dst, 0x00)
+	}
+
+	return dst
+}
+
+// ConsumeVarint parses a varint at the start of src, returning its value, the
+// number of bytes of padding at the end of it (the longForm that AppendVarint
+// would need to reproduce it), and its total length n.
+//
+// ok is false if src ends before the varint does, or if the varint does not
+// fit in 64 bits.
+func ConsumeVarint(src []byte) (value uint64, extraBytes int, n int, ok bool) {
+	for {
+		if n == len(src) {
+			return 0, 0, 0, false
+		}
+
+		b := src[n]
+		if n == 9 && b > 1 {
+			// The tenth byte has a special upper limit: it may only be 0 or 1.
+			return 0, 0, 0, false
+		}
+
+		value |= uint64(b&0x7f) << (n * 7)
+		n++
+
+		if b&0x7f == 0 {
+			extraBytes++
+		} else {
+			extraBytes = 0
+		}
+
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	if value == 0 {
+		extraBytes--
+	}
+	return value, extraBytes, n, true
+}
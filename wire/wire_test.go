@@ -0,0 +1,63 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVarint(t *testing.T) {
+	tests := []struct {
+		value    uint64
+		longForm int
+		want     []byte
+	}{
+		{0, 0, []byte{0x00}},
+		{1, 0, []byte{0x01}},
+		{300, 0, []byte{0xac, 0x02}},
+		{^uint64(0), 0, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{0, 1, []byte{0x80, 0x00}},
+		{1, 3, []byte{0x81, 0x80, 0x80, 0x00}},
+		{300, 2, []byte{0xac, 0x82, 0x80, 0x00}},
+	}
+
+	for _, tt := range tests {
+		got := AppendVarint([]byte{0xee}, tt.value, tt.longForm)
+		if !bytes.Equal(got[1:], tt.want) || got[0] != 0xee {
+			t.Errorf("AppendVarint(%d, %d) = %x, want %x", tt.value, tt.longForm, got[1:], tt.want)
+		}
+
+		value, extra, n, ok := ConsumeVarint(append(tt.want, 0xff))
+		if !ok || value != tt.value || extra != tt.longForm || n != len(tt.want) {
+			t.Errorf("ConsumeVarint(%x) = %d, %d, %d, %v; want %d, %d, %d, true",
+				tt.want, value, extra, n, ok, tt.value, tt.longForm, len(tt.want))
+		}
+	}
+}
+
+func TestConsumeVarintError(t *testing.T) {
+	for _, src := range [][]byte{
+		{},
+		{0x80},
+		{0xff, 0xff},
+		// The tenth byte may only be 0 or 1.
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02},
+	} {
+		if _, _, _, ok := ConsumeVarint(src); ok {
+			t.Errorf("ConsumeVarint(%x) succeeded, want failure", src)
+		}
+	}
+}
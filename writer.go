@@ -15,17 +15,25 @@
 package protoscope
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 
 	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 
 	"github.com/protocolbuffers/protoscope/internal/print"
+	"github.com/protocolbuffers/protoscope/wire"
 )
 
 // WriterOptions represents options that can be passed to control the writer's
@@ -41,36 +49,252 @@ type WriterOptions struct {
 	// Always prints the wire type of a field. Also disables !{} group syntax,
 	// like NoGroups
 	ExplicitWireTypes bool
+	// Like ExplicitWireTypes, but prints wire types as numbers, such as 3:0,
+	// rather than by name.
+	NumericWireTypes bool
 	// Never prints {}; instead, prints out an explicit length prefix (but still
 	// indents the contents of delimited things.
 	ExplicitLengthPrefixes bool
+	// Never prints long-form:N, so that reassembling the output produces the
+	// minimal encoding of every varint, tag, and length prefix. This means that
+	// the output no longer round-trips to the original bytes. Length prefixes
+	// printed due to ExplicitLengthPrefixes are not adjusted to match.
+	MinimalVarints bool
+	// NormalizeVarints is another name for MinimalVarints; setting either has
+	// the same effect.
+	NormalizeVarints bool
+	// Prints the fields of each message in order of field number, keeping
+	// fields with the same number in the order they appear, so that messages
+	// whose fields were encoded in different orders print the same. This means
+	// that the output no longer round-trips to the original bytes. The fields
+	// of a group are not reordered.
+	SortFields bool
+	// Guesses whether length-prefixed fields that are neither messages nor
+	// strings are packed repeated fields, printing their elements if they look
+	// like floats, doubles, or varints. With a Schema, fields whose type is
+	// known are always printed according to it.
+	PackedFields bool
 
 	// Schema is a Descriptor that describes the message type we're expecting to
 	// disassemble, if any.
 	Schema protoreflect.MessageDescriptor
+	// ExtensionRegistry, if not nil, is consulted for extensions of the
+	// message types in Schema, for fields that Schema does not itself declare.
+	ExtensionRegistry *protoregistry.Types
 	// Prints field names, using Schema as the source of names.
 	PrintFieldNames bool
 	// Prints enum value names, using Schema as the source of names.
 	PrintEnumNames bool
+	// Names of fields to print when Schema does not name them, keyed by their
+	// dotted paths of field numbers from the top-level message, such as "1.2"
+	// for field 2 of the message in field 1. Groups count as part of the path.
+	FieldNames map[string]string
+	// Prints the oneof that each field is a member of, using Schema, and warns
+	// when more than one member of a oneof appears in the same message.
+	PrintOneofs bool
+	// Prints the value of each google.protobuf.Any field in Schema as the
+	// message type named by its type URL, if that type can be found in Files.
+	ExpandAny bool
+	// Files is where ExpandAny looks up message types. If nil,
+	// protoregistry.GlobalFiles is used.
+	Files *protoregistry.Files
+
+	// The number of levels of length-prefixed fields that may be printed as
+	// messages; fields nested deeper than this are printed as strings or
+	// bytes, even if they parse as messages. Zero means there is no limit.
+	MessageRecursionDepth int
+	// Like MessageRecursionDepth, but length-prefixed fields nested deeper than
+	// this are always printed as hex, so that the cost of disassembling
+	// untrusted input is bounded. Zero means there is no limit.
+	MaxDepth int
+
+	// Prints the bytes that make up each field's tag in a comment.
+	ShowTagBytes bool
+	// Prints the offset in the input at which each field starts in a comment.
+	ShowOffsets bool
+
+	// Appends a comment listing the offsets of every non-minimal varint, tag,
+	// and length prefix, as well as every repeated occurrence of a field that
+	// Schema says is not repeated.
+	CanonicalityReport bool
+
+	// Treats the input as a sequence of gRPC length-prefixed messages, each
+	// with a five-byte header that is printed as hex. Anything after the last
+	// complete frame is printed as hex.
+	GRPCFraming bool
+	// Treats the input as a sequence of messages that are each prefixed with
+	// their length as a varint, printing each one as a bare {} block.
+	DelimitedStream bool
+
+	// If not empty, a path of field numbers to descend along, printing only
+	// the fields at the end of the path; every other field is elided. See
+	// ResolveFocusPath for converting a path of field names.
+	FocusPath []int
+
+	// Field numbers of fixed-width or bytes fields whose contents should also
+	// be interpreted as a sequence of little-endian 16-bit floats, which are
+	// shown in a remark. The field itself is printed as usual.
+	HalfFloatFields []int
+	// The 16-bit float format used for HalfFloatFields.
+	HalfFloatFormat HalfFloatFormat
+
+	// Prefixes every line of output with "# ", so that it can be embedded in
+	// another Protoscope file as a comment.
+	CommentEverything bool
+
+	// The string to use for each level of indentation, such as "\t" or "│ ".
+	// Defaults to two spaces. Output indented with anything other than
+	// whitespace cannot be reassembled.
+	IndentString string
+
+	// The number of bytes to print on each line of a hex literal. Zero means
+	// the default of 40.
+	HexWidth int
+	// The number of bytes to print on each line of a quoted string. Zero means
+	// the default of 80.
+	StringWidth int
+	// If positive, the number of columns that output should fit within. This
+	// overrides HexWidth and StringWidth, deriving them from the space left at
+	// each level of indentation, and the number of columns that packed fields
+	// are wrapped into. Comments are not taken into account.
+	TargetColumns int
+	// Controls the offset printed in a comment beside each line of a hex
+	// literal, if any.
+	HexOffsetStyle HexOffsetStyle
+
+	// The number of folded blocks that a block may contain and still be folded
+	// onto one line itself. Zero or a negative number means no limit.
+	MaxFolds int
+	// Never folds blocks onto one line, printing every field on a line of its
+	// own.
+	NoFold bool
+	// Separates the elements of packed fields with commas. These are purely
+	// cosmetic, since the scanner treats commas as whitespace.
+	CommaSeparated bool
+	// Prints each comment directly after its line, rather than aligning it
+	// with comments on neighboring lines. This keeps unrelated lines from
+	// changing when one line's length does.
+	NoAlignComments bool
+
+	// Highlights field numbers, wire types, strings, and comments with ANSI
+	// escape codes, for printing to a terminal. The output cannot be
+	// reassembled.
+	Color bool
+	// Ends each line with "\r\n" rather than "\n".
+	CRLF bool
+
+	// When a fixed-width field is printed as a float, also prints its value as
+	// an unsigned (and, if different, signed) integer in a comment.
+	FixedBothInterpretations bool
+	// Prints the alternate numeric interpretation of each fixed-width field in
+	// a comment: a float's integer value, as with FixedBothInterpretations,
+	// which this implies, and an integer's float value.
+	ShowAltNumeric bool
+
+	// When an integer looks like a Unix timestamp in seconds or milliseconds
+	// between the years 2000 and 2100, prints it as an RFC 3339 time in a
+	// comment.
+	GuessTimestamps bool
+
+	// The largest fraction of a length-prefixed field's runes that may be
+	// unprintable for it to be printed as a quoted string rather than hex.
+	// Zero means the default of 0.3, and a threshold of 1 or more allows any
+	// valid UTF-8. A negative threshold means that no field is printed as a
+	// string, as with NoQuotedStrings; to allow no unprintable runes at all,
+	// use a tiny positive threshold such as 1e-9.
+	StringThreshold float64
+
+	// For length-prefixed fields printed as hex, also prints their contents
+	// decoded as UTF-16LE in a comment, if they look like UTF-16LE text.
+	DetectUTF16 bool
+
+	// For varint fields without a type in Schema that take more than one byte,
+	// also prints the zigzag-decoded value and, if it was printed as negative,
+	// the unsigned value in comments.
+	AnnotateVarints bool
+	// Prints varints without a type in Schema as zigzag-encoded sint64s.
+	AllVarintsZigzag bool
 }
 
+// HexOffsetStyle is a style of offset to print alongside hex literals.
+type HexOffsetStyle int
+
+const (
+	// No offsets are printed.
+	OffsetNone HexOffsetStyle = iota
+	// Offsets from the start of the input, in hex.
+	OffsetHexAbsolute
+	// Offsets from the start of the input, in decimal.
+	OffsetDecAbsolute
+	// Offsets from the start of the hex literal, in hex.
+	OffsetHexRelative
+)
+
+// HalfFloatFormat is a 16-bit floating point format.
+type HalfFloatFormat int
+
+const (
+	// IEEE 754 half precision, also known as fp16.
+	HalfFloatIEEE HalfFloatFormat = iota
+	// The upper half of an IEEE 754 single, also known as bfloat16.
+	HalfFloatBfloat16
+)
+
 func Write(src []byte, opts WriterOptions) string {
-	w := writer{WriterOptions: opts}
+	return write(src, opts, nil)
+}
+
+// AppendWrite is like Write, but appends the output to dst and returns the
+// extended buffer, so that a buffer may be reused across many calls.
+func AppendWrite(dst, src []byte, opts WriterOptions) []byte {
+	return appendWrite(dst, src, opts, nil)
+}
+
+func write(src []byte, opts WriterOptions, report *Report) string {
+	return string(appendWrite(nil, src, opts, report))
+}
+
+func appendWrite(dst, src []byte, opts WriterOptions, report *Report) []byte {
+	w := writer{WriterOptions: opts, src: src, report: report}
 	w.Indent = 2
-	w.MaxFolds = 3
+	w.Printer.IndentString = opts.IndentString
+	if opts.CommentEverything {
+		w.Prefix = "# "
+	}
+	w.Printer.MaxFolds = 3
+	if opts.MaxFolds != 0 {
+		w.Printer.MaxFolds = opts.MaxFolds
+		w.Printer.CountFolds = true
+	}
+	w.Printer.NoFold = opts.NoFold
+	w.Printer.NoAlignRemarks = opts.NoAlignComments
+	w.Printer.Color = opts.Color
+	w.Printer.CRLF = opts.CRLF
+	// Most fields take up a handful of bytes and are printed on a line of
+	// their own.
+	w.Grow(len(src) / 8)
+	if opts.NumericWireTypes {
+		w.WriterOptions.ExplicitWireTypes = true
+	}
+	if opts.NormalizeVarints {
+		w.WriterOptions.MinimalVarints = true
+	}
+	if opts.ShowAltNumeric {
+		w.WriterOptions.FixedBothInterpretations = true
+	}
 
 	if opts.Schema != nil {
 		w.descs.Push(opts.Schema)
 	}
 
-	for len(src) > 0 {
-		w.NewLine()
-		rest, ok := w.decodeField(src)
-		if !ok {
-			w.DiscardLine()
-			break
-		}
-		src = rest
+	if len(opts.FocusPath) > 0 {
+		src = w.writeFocused(src, opts.FocusPath)
+	} else if opts.GRPCFraming {
+		src = w.writeGRPCFrames(src)
+	} else if opts.DelimitedStream {
+		src = w.writeDelimited(src)
+	} else {
+		src = w.decodeFields(src)
 	}
 
 	// Order does not matter for fixing up unclosed groups
@@ -79,7 +303,11 @@ func Write(src []byte, opts WriterOptions) string {
 	}
 
 	w.dumpHexString(src)
-	return string(w.Finish())
+
+	if w.CanonicalityReport {
+		w.printIssues()
+	}
+	return w.AppendFinish(dst)
 }
 
 type line struct {
@@ -94,6 +322,10 @@ type line struct {
 type group struct {
 	number  uint64
 	hasDesc bool
+	// seen tracks non-repeated fields seen in this group, and seenOneofs the
+	// last member of each oneof.
+	seen       map[uint64]bool
+	seenOneofs map[protoreflect.Name]protoreflect.FieldNumber
 }
 
 type writer struct {
@@ -102,6 +334,167 @@ type writer struct {
 
 	groups print.Stack[group]
 	descs  print.Stack[protoreflect.MessageDescriptor]
+
+	// src is the entire input, used for computing offsets.
+	src []byte
+	// depth is the number of length-prefixed fields we are currently inside.
+	depth int
+	// path is the dotted path of the message we are currently inside, ending
+	// in a dot unless it is the top-level message, for FieldNames.
+	path string
+	// report, if not nil, accumulates the interpretation of each field.
+	report *Report
+
+	// issues accumulates non-canonical encodings for CanonicalityReport, and
+	// seen tracks the non-repeated fields seen in the current message.
+	issues []issue
+	seen   map[uint64]bool
+	// seenOneofs tracks the last member of each oneof seen in the current
+	// message, for PrintOneofs.
+	seenOneofs map[protoreflect.Name]protoreflect.FieldNumber
+
+	// anyType is the message type of the value of the Any we are currently
+	// inside of, if ExpandAny was able to resolve it.
+	anyType protoreflect.MessageDescriptor
+}
+
+// An issue is a non-canonical encoding found in the input.
+type issue struct {
+	offset int
+	desc   string
+}
+
+// noteIssue records a non-canonical encoding starting at src.
+func (w *writer) noteIssue(src []byte, format string, args ...any) {
+	if w.CanonicalityReport {
+		w.issues = append(w.issues, issue{w.offset(src), fmt.Sprintf(format, args...)})
+	}
+}
+
+// noteField records that a field was seen in the current message, noting an
+// issue if it is a non-repeated field that was already seen.
+func (w *writer) noteField(tag []byte, number uint64, fd protoreflect.FieldDescriptor) {
+	if !w.CanonicalityReport || fd == nil || fd.Cardinality() == protoreflect.Repeated {
+		return
+	}
+
+	seen := &w.seen
+	if g := w.groups.Peek(); g != nil {
+		seen = &g.seen
+	}
+	if *seen == nil {
+		*seen = make(map[uint64]bool)
+	}
+
+	if (*seen)[number] {
+		w.noteIssue(tag, "duplicate non-repeated field %d (%s)", number, fd.Name())
+	}
+	(*seen)[number] = true
+}
+
+// fieldPath returns the dotted path of the field with the given number in the
+// current message, counting groups as part of the path.
+func (w *writer) fieldPath(number uint64) string {
+	var b strings.Builder
+	b.WriteString(w.path)
+	for _, g := range w.groups {
+		fmt.Fprintf(&b, "%d.", g.number)
+	}
+	fmt.Fprintf(&b, "%d", number)
+	return b.String()
+}
+
+// noteOneof remarks on the oneof that fd is a member of, if PrintOneofs is set,
+// warning if a different member of it was already seen in the current message.
+func (w *writer) noteOneof(fd protoreflect.FieldDescriptor) {
+	if !w.PrintOneofs || fd == nil {
+		return
+	}
+	od := fd.ContainingOneof()
+	if od == nil || od.IsSynthetic() {
+		return
+	}
+	w.Remarkf("oneof %q", od.Name())
+
+	seen := &w.seenOneofs
+	if g := w.groups.Peek(); g != nil {
+		seen = &g.seenOneofs
+	}
+	if *seen == nil {
+		*seen = make(map[protoreflect.Name]protoreflect.FieldNumber)
+	}
+
+	if prev, ok := (*seen)[od.Name()]; ok && prev != fd.Number() {
+		w.Remarkf("warning: oneof already set by field %d", prev)
+	}
+	(*seen)[od.Name()] = fd.Number()
+}
+
+// printIssues prints a comment block listing every issue found.
+func (w *writer) printIssues() {
+	sort.SliceStable(w.issues, func(i, j int) bool {
+		return w.issues[i].offset < w.issues[j].offset
+	})
+
+	w.NewLine()
+	if len(w.issues) == 0 {
+		w.Write("# canonicality: no non-canonical encodings found")
+		return
+	}
+	w.Write("# canonicality: found non-canonical encodings:")
+	for _, issue := range w.issues {
+		w.NewLine()
+		w.Writef("#   %#x: %s", issue.offset, issue.desc)
+	}
+}
+
+// offset returns the offset of a suffix of w.src.
+func (w *writer) offset(rest []byte) int {
+	return cap(w.src) - cap(rest)
+}
+
+// record appends a field to the report, returning its index.
+func (w *writer) record(number uint64, class FieldClass, tag []byte) int {
+	if w.report == nil {
+		return -1
+	}
+	w.report.Fields = append(w.report.Fields, FieldReport{
+		Number: number,
+		Class:  class,
+		Depth:  w.depth + len(w.groups),
+		Offset: w.offset(tag),
+	})
+	return len(w.report.Fields) - 1
+}
+
+// A reportMark records how many report fields and issues have been
+// accumulated, so that resetReport can roll them back.
+type reportMark struct {
+	fields, issues int
+}
+
+// reportMark returns a mark that resetReport can roll back to.
+func (w *writer) reportMark() reportMark {
+	m := reportMark{issues: len(w.issues)}
+	if w.report != nil {
+		m.fields = len(w.report.Fields)
+	}
+	return m
+}
+
+// resetReport discards all fields and issues recorded after a mark.
+func (w *writer) resetReport(mark reportMark) {
+	if w.report != nil {
+		w.report.Fields = w.report.Fields[:mark.fields]
+	}
+	w.issues = w.issues[:mark.issues]
+}
+
+// classify changes the class of a field previously added with record.
+func (w *writer) classify(i int, class FieldClass) {
+	if w.report != nil && i >= 0 {
+		w.report.Fields[i].Class = class
+	}
 }
 
 func (w *writer) dumpHexString(src []byte) {
@@ -109,28 +502,161 @@ func (w *writer) dumpHexString(src []byte) {
 		return
 	}
 
+	width := w.hexWidth()
+	start := w.offset(src)
 	w.NewLine()
 	w.Write("`")
-	for i, b := range src {
-		if i > 0 && i%40 == 0 {
+	for i := 0; i < len(src); i += width {
+		if i > 0 {
 			w.Write("`")
 			w.NewLine()
 			w.Write("`")
 		}
-		w.Writef("%02x", b)
+		switch w.HexOffsetStyle {
+		case OffsetHexAbsolute:
+			w.Remarkf("%#x", start+i)
+		case OffsetDecAbsolute:
+			w.Remarkf("%d", start+i)
+		case OffsetHexRelative:
+			w.Remarkf("+%#x", i)
+		}
+
+		chunk := src[i:min(i+width, len(src))]
+		l := w.Current()
+		l.Grow(hex.EncodedLen(len(chunk)))
+		buf := l.AvailableBuffer()[:hex.EncodedLen(len(chunk))]
+		hex.Encode(buf, chunk)
+		l.Write(buf)
 	}
 	w.Write("`")
 }
 
+// available returns the number of columns left on a line at the current
+// indentation level, given TargetColumns, minus the two columns used by
+// delimiters.
+func (w *writer) available() int {
+	if w.TargetColumns <= 0 {
+		return 0
+	}
+	indent := 2
+	if w.Printer.IndentString != "" {
+		indent = print.DisplayWidth(w.Printer.IndentString)
+	}
+	return w.TargetColumns - w.Depth()*indent - 2
+}
+
+// hexWidth returns the number of bytes to print on each line of a hex literal.
+func (w *writer) hexWidth() int {
+	if w.TargetColumns > 0 {
+		if width := w.available() / 2; width > 4 {
+			return width
+		}
+		return 4
+	}
+	if w.HexWidth > 0 {
+		return w.HexWidth
+	}
+	return 40
+}
+
+// stringWidth returns the number of bytes to print on each line of a quoted
+// string.
+func (w *writer) stringWidth() int {
+	if w.TargetColumns > 0 {
+		if width := w.available(); width > 8 {
+			return width
+		}
+		return 8
+	}
+	if w.StringWidth > 0 {
+		return w.StringWidth
+	}
+	return 80
+}
+
+// longForm prints a long-form:N prefix followed by sep, unless MinimalVarints
+// is set.
+func (w *writer) longForm(extra int, sep string) {
+	if !w.MinimalVarints {
+		w.Writef("long-form:%d%s", extra, sep)
+	}
+}
+
+// ANSI escape codes used by WriterOptions.Color.
+const (
+	colorField    = "\x1b[34m"
+	colorWireType = "\x1b[35m"
+	colorString   = "\x1b[32m"
+	colorReset    = "\x1b[0m"
+)
+
+// setColor writes the escape code c, if Color is set.
+func (w *writer) setColor(c string) {
+	if w.WriterOptions.Color {
+		w.Write(c)
+	}
+}
+
+// wireTypeNumbers maps the names of wire types to their numbers, for
+// NumericWireTypes.
+var wireTypeNumbers = map[string]protowire.Type{
+	"VARINT": protowire.VarintType,
+	"I64":    protowire.Fixed64Type,
+	"LEN":    protowire.BytesType,
+	"SGROUP": protowire.StartGroupType,
+	"EGROUP": protowire.EndGroupType,
+	"I32":    protowire.Fixed32Type,
+}
+
+// writeWireType writes the name of a wire type, or its number if
+// NumericWireTypes is set.
+func (w *writer) writeWireType(name string) {
+	w.setColor(colorWireType)
+	if w.NumericWireTypes {
+		w.Write(int(wireTypeNumbers[name]))
+	} else {
+		w.Write(name)
+	}
+	w.setColor(colorReset)
+}
+
+// resetRecord finishes one record of a stream of messages, such as a gRPC
+// frame, so that nothing about it carries over into the next.
+func (w *writer) resetRecord() {
+	// Groups cannot span records.
+	for range w.groups {
+		w.resetGroup()
+	}
+	w.groups, w.seen, w.seenOneofs = nil, nil, nil
+}
+
 func (w *writer) resetGroup() {
 	// Do some surgery on the line with the !{ to replace it with an SGROUP.
 	start := w.DropBlock()
 
-	if !w.NoGroups {
-		// Remove the trailing " !{"
-		start.Truncate(start.Len() - 3)
+	// With explicit SGROUPs, there is no !{ to replace.
+	if w.ExplicitWireTypes || w.NoGroups {
+		return
+	}
+
+	// The " !{" is usually at the end of the line, but search for it rather
+	// than assuming so, in case anything was written after it.
+	line := start.Bytes()
+	i := bytes.LastIndex(line, []byte(" !{"))
+	if i < 0 {
+		return
+	}
+	rest := append([]byte(nil), line[i+3:]...)
+	start.Truncate(i)
+	if w.WriterOptions.Color {
+		start.WriteString(colorWireType + "SGROUP" + colorReset)
+	} else {
 		start.WriteString("SGROUP")
 	}
+	if len(rest) > 0 {
+		start.WriteString(" ")
+		start.Write(rest)
+	}
 }
 
 func (w *writer) decodeVarint(src []byte, fd protoreflect.FieldDescriptor) ([]byte, bool) {
@@ -138,15 +664,17 @@ func (w *writer) decodeVarint(src []byte, fd protoreflect.FieldDescriptor) ([]by
 	if !ok {
 		return nil, false
 	}
-	src = rest
-
 	if extra > 0 {
-		w.Writef("long-form:%d ", extra)
+		w.noteIssue(src, "non-minimal varint (%d extra bytes)", extra)
+		w.longForm(extra, " ")
 	}
+	src = rest
 
 	ftype := protoreflect.Int64Kind
 	if fd != nil {
 		ftype = fd.Kind()
+	} else if w.AllVarintsZigzag {
+		ftype = protoreflect.Sint64Kind
 	}
 
 	// Pick a deserialization based on the type. If the type doesn't really
@@ -154,6 +682,13 @@ func (w *writer) decodeVarint(src []byte, fd protoreflect.FieldDescriptor) ([]by
 	// everything is 64 bit here.
 	switch ftype {
 	case protoreflect.BoolKind:
+		// Anything other than 0 or 1, such as a stray byte in a packed field, is
+		// printed as an integer. So is a non-minimal encoding, since long-form:N
+		// cannot be followed by true or false.
+		if extra > 0 && !w.MinimalVarints {
+			w.WriteUint(value)
+			return src, true
+		}
 		switch value {
 		case 0:
 			w.Write("false")
@@ -165,27 +700,141 @@ func (w *writer) decodeVarint(src []byte, fd protoreflect.FieldDescriptor) ([]by
 		fallthrough
 	case protoreflect.Uint32Kind, protoreflect.Uint64Kind,
 		protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
-		w.Write(value)
+		w.WriteUint(value)
 	case protoreflect.Sint32Kind, protoreflect.Sint64Kind:
 		// Undo ZigZag encoding, then print as signed.
 		value = (value >> 1) ^ -(value & 1)
-		w.Writef("%dz", int64(value))
+		w.WriteInt(int64(value))
+		w.Write("z")
 	case protoreflect.EnumKind:
 		if w.PrintEnumNames && value < math.MaxInt32 {
-			ed := fd.Enum().Values()
-			edv := ed.ByNumber(protoreflect.EnumNumber(value))
-			if edv != nil {
-				w.Remark(string(edv.Name()))
-			}
+			w.remarkEnumName(fd, protoreflect.EnumNumber(value))
 		}
-		fallthrough
+		w.WriteInt(int64(value))
+		return src, true
 	default:
-		w.Write(int64(value))
+		w.WriteInt(int64(value))
 	}
 
+	w.remarkTimestamp(int64(value))
 	return src, true
 }
 
+// remarkVarint adds remarks with the other ways an untyped varint could be
+// read, if it is large enough for them to be interesting.
+func (w *writer) remarkVarint(value uint64) {
+	if value < 0x80 {
+		return
+	}
+	if int64(value) < 0 {
+		w.Remarkf("%d", value)
+	}
+	w.Remarkf("%dz", int64((value>>1)^-(value&1)))
+}
+
+// Bounds on the Unix timestamps that GuessTimestamps recognizes.
+var (
+	minTimestamp = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxTimestamp = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// remarkTimestamp adds a remark interpreting v as a Unix timestamp in seconds
+// or milliseconds, if GuessTimestamps is set and v is in a plausible range.
+func (w *writer) remarkTimestamp(v int64) {
+	if !w.GuessTimestamps {
+		return
+	}
+	switch {
+	case v >= minTimestamp.Unix() && v < maxTimestamp.Unix():
+		w.Remark(time.Unix(v, 0).UTC().Format(time.RFC3339))
+	case v >= minTimestamp.UnixMilli() && v < maxTimestamp.UnixMilli():
+		w.Remark(time.UnixMilli(v).UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	}
+}
+
+// remarkEnumName adds a remark with the name of fd's enum value numbered n, if
+// there is one.
+//
+// If the enum allows aliases, the first name declared for n is used, and any
+// others are listed after it.
+func (w *writer) remarkEnumName(fd protoreflect.FieldDescriptor, n protoreflect.EnumNumber) {
+	var names []string
+	values := fd.Enum().Values()
+	for i := 0; i < values.Len(); i++ {
+		if v := values.Get(i); v.Number() == n {
+			names = append(names, string(v.Name()))
+		}
+	}
+
+	switch len(names) {
+	case 0:
+	case 1:
+		w.Remark(names[0])
+	default:
+		w.Remarkf("%s (aliases: %s)", names[0], strings.Join(names[1:], ", "))
+	}
+}
+
+// remarkHalfFloats adds a remark interpreting src as 16-bit floats, if number
+// is one of the HalfFloatFields. Returns whether it did so.
+func (w *writer) remarkHalfFloats(number uint64, src []byte) bool {
+	found := false
+	for _, n := range w.HalfFloatFields {
+		if uint64(n) == number {
+			found = true
+			break
+		}
+	}
+	if !found || len(src)%2 != 0 {
+		return false
+	}
+
+	var b strings.Builder
+	if w.HalfFloatFormat == HalfFloatBfloat16 {
+		b.WriteString("bf16:")
+	} else {
+		b.WriteString("fp16:")
+	}
+	for i := 0; i < len(src); i += 2 {
+		bits := binary.LittleEndian.Uint16(src[i:])
+		var f float32
+		if w.HalfFloatFormat == HalfFloatBfloat16 {
+			f = math.Float32frombits(uint32(bits) << 16)
+		} else {
+			f = halfToFloat(bits)
+		}
+		if i != 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(" ")
+		b.WriteString(strconv.FormatFloat(float64(f), 'g', -1, 32))
+	}
+	w.Remark(b.String())
+	return true
+}
+
+// halfToFloat converts an IEEE 754 half-precision float to a float32.
+func halfToFloat(bits uint16) float32 {
+	sign := uint32(bits>>15) << 31
+	exp := uint32(bits>>10) & 0x1f
+	mant := uint32(bits) & 0x3ff
+
+	switch {
+	case exp == 0x1f:
+		// Infinities and NaNs.
+		return math.Float32frombits(sign | 0xff<<23 | mant<<13)
+	case exp != 0:
+		return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+	default:
+		// Zeros and subnormals, which are mant * 2^-24.
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	}
+}
+
 // decodeFixed prints out a single fixed-length value.
 //
 // This monster of a generic function exists to reduce keeping the two copies of
@@ -211,24 +860,31 @@ func printFixed[
 	switch ftype {
 	case protoreflect.Uint32Kind, protoreflect.Uint64Kind,
 		protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
-		w.Writef("%di%s", value, suffix)
+		w.WriteUint(uint64(value))
+		w.Write("i")
+		w.Write(suffix)
+		w.remarkTimestamp(int64(value))
+		remarkAltFloat(w, value, suffix)
 	case protoreflect.EnumKind:
 		if w.PrintEnumNames && value < math.MaxInt32 {
-			ed := fd.Enum().Values()
-			edv := ed.ByNumber(protoreflect.EnumNumber(value))
-			if edv != nil {
-				w.Remark(string(edv.Name()))
-			}
+			w.remarkEnumName(fd, protoreflect.EnumNumber(value))
 		}
 		fallthrough
 	case protoreflect.Int32Kind, protoreflect.Int64Kind,
 		protoreflect.Sint32Kind, protoreflect.Sint64Kind,
 		protoreflect.Sfixed32Kind, protoreflect.Sfixed64Kind,
 		protoreflect.BoolKind:
-		w.Writef("%di%s", I(value), suffix)
+		w.WriteInt(int64(I(value)))
+		w.Write("i")
+		w.Write(suffix)
+		if ftype != protoreflect.EnumKind && ftype != protoreflect.BoolKind {
+			w.remarkTimestamp(int64(I(value)))
+		}
+		remarkAltFloat(w, value, suffix)
 	default:
 		// Assume this is a float by default.
 		fvalue := float64(itof(value))
+		isFloat := true
 		if math.IsInf(fvalue, 1) {
 			w.Writef("inf%s", suffix)
 		} else if math.IsInf(fvalue, -1) {
@@ -242,11 +898,25 @@ func printFixed[
 				if suffix == "64" {
 					w.Write(s)
 				} else {
-					w.Writef("%si%s", s, suffix)
+					w.Write(s)
+					w.Write("i")
+					w.Write(suffix)
 				}
 				w.Remarkf("%#xi%s", U(value), suffix)
 			} else {
-				w.Writef("%di%s", I(value), suffix)
+				w.WriteInt(int64(I(value)))
+				w.Write("i")
+				w.Write(suffix)
+				w.remarkTimestamp(int64(I(value)))
+				remarkAltFloat(w, value, suffix)
+				isFloat = false
+			}
+		}
+
+		if isFloat && w.FixedBothInterpretations {
+			w.Remarkf("%di%s", value, suffix)
+			if I(value) < 0 {
+				w.Remarkf("%di%s", I(value), suffix)
 			}
 		}
 	}
@@ -254,6 +924,35 @@ func printFixed[
 	return src, true
 }
 
+// remarkAltFloat adds a remark with value, a fixed-width field printed as an
+// integer, as a float, if ShowAltNumeric is set.
+func remarkAltFloat[U uint32 | uint64](w *writer, value U, suffix string) {
+	if !w.ShowAltNumeric {
+		return
+	}
+
+	var f float64
+	switch v := any(value).(type) {
+	case uint32:
+		f = float64(math.Float32frombits(v))
+	case uint64:
+		f = math.Float64frombits(v)
+	}
+
+	switch {
+	case math.IsNaN(f):
+		// The bits of a NaN are exactly the integer that was printed.
+	case math.IsInf(f, 1):
+		w.Remarkf("inf%s", suffix)
+	case math.IsInf(f, -1):
+		w.Remarkf("-inf%s", suffix)
+	case suffix == "64":
+		w.Remark(ftoa(value, true))
+	default:
+		w.Remarkf("%si%s", ftoa(value, true), suffix)
+	}
+}
+
 func (w *writer) decodeI32(src []byte, fd protoreflect.FieldDescriptor) ([]byte, bool) {
 	if len(src) < 4 {
 		return nil, false
@@ -274,7 +973,70 @@ func (w *writer) decodeI64(src []byte, fd protoreflect.FieldDescriptor) ([]byte,
 	return printFixed[uint64, int64, float64](w, value, "64", math.Float64frombits, src, fd)
 }
 
+// decodeFields prints the fields in src, returning whatever is left over after
+// the last one that could be printed.
+func (w *writer) decodeFields(src []byte) []byte {
+	if w.SortFields {
+		if fields, ok := splitFields(src); ok {
+			sort.SliceStable(fields, func(i, j int) bool {
+				return fields[i].number < fields[j].number
+			})
+			for _, f := range fields {
+				// A group's fields are printed by this loop, too.
+				chunk := f.src
+				for len(chunk) > 0 {
+					w.NewLine()
+					rest, ok := w.decodeField(chunk)
+					if !ok {
+						w.DiscardLine()
+						w.dumpHexString(chunk)
+						break
+					}
+					chunk = rest
+				}
+			}
+			return src[len(src):]
+		}
+	}
+
+	for len(src) > 0 {
+		w.NewLine()
+		rest, ok := w.decodeField(src)
+		if !ok {
+			// Clip off an incompletely printed line.
+			w.DiscardLine()
+			break
+		}
+		src = rest
+	}
+	return src
+}
+
+// A rawField is a field as split out of a message by splitFields.
+type rawField struct {
+	number protowire.Number
+	// src is the field's tag and value, or a whole group, including its
+	// EGROUP tag.
+	src []byte
+}
+
+// splitFields splits src into its fields, returning false if it is not a
+// well-formed message.
+func splitFields(src []byte) ([]rawField, bool) {
+	var fields []rawField
+	for len(src) > 0 {
+		number, _, n := protowire.ConsumeField(src)
+		if n < 0 {
+			return nil, false
+		}
+		fields = append(fields, rawField{number, src[:n]})
+		src = src[n:]
+	}
+	return fields, true
+}
+
 func (w *writer) decodeField(src []byte) ([]byte, bool) {
+	tag := src
 	rest, value, extra, ok := decodeVarint(src)
 	if !ok {
 		return nil, false
@@ -287,49 +1049,120 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 	}
 
 	if extra > 0 {
-		w.Writef("long-form:%d ", extra)
+		w.noteIssue(tag, "non-minimal tag (%d extra bytes)", extra)
+		w.longForm(extra, " ")
 	}
 	number := value >> 3
-	w.Writef("%d:", number)
+	w.setColor(colorField)
+	w.WriteUint(number)
+	w.Write(":")
+	w.setColor(colorReset)
+	if w.ShowOffsets {
+		w.Remarkf("@%#x", w.offset(tag))
+	}
 
 	var fd protoreflect.FieldDescriptor
+	inMapEntry, inAny, inWellKnown := false, false, false
 	if d := w.descs.Peek(); d != nil && *d != nil {
+		inMapEntry = (*d).IsMapEntry()
+		inWellKnown = isWellKnown((*d).FullName())
+		inAny = (*d).FullName() == anyName
 		fd = (*d).Fields().ByNumber(protowire.Number(number))
+		if fd == nil && w.ExtensionRegistry != nil {
+			xt, err := w.ExtensionRegistry.FindExtensionByNumber((*d).FullName(), protowire.Number(number))
+			if err == nil {
+				fd = xt.TypeDescriptor()
+			}
+		}
+	}
+
+	// The key and value of a map entry are labeled by the map field's type
+	// instead, so that the entry can fit on one line. The same goes for the
+	// fields of well-known types.
+	if w.PrintFieldNames && fd != nil && !inMapEntry && !inWellKnown {
+		if fd.IsExtension() {
+			w.Remarkf("[%s]", fd.FullName())
+		} else {
+			w.Remark(fd.Name())
+		}
+		if fd.IsMap() {
+			w.Remarkf("map<%s, %s>", typeName(fd.MapKey()), typeName(fd.MapValue()))
+		}
+	} else if len(w.FieldNames) > 0 && value&0x7 != 4 {
+		if name, ok := w.FieldNames[w.fieldPath(number)]; ok {
+			w.Remark(name)
+		}
 	}
 
-	if w.PrintFieldNames && fd != nil {
-		w.Remark(fd.Name())
+	if w.ShowTagBytes {
+		var b strings.Builder
+		b.WriteString("tag")
+		for _, c := range tag[:len(tag)-len(src)] {
+			fmt.Fprintf(&b, " %#02x", c)
+		}
+		w.Remark(b.String())
+	}
+
+	// EGROUP tags are not fields in their own right.
+	field := -1
+	if value&0x7 != 4 {
+		field = w.record(number, ClassFailed, tag)
+		w.noteField(tag, number, fd)
+		w.noteOneof(fd)
+	}
+	fail := func() ([]byte, bool) {
+		w.classify(field, ClassFailed)
+		return nil, false
 	}
 
 	switch value & 0x7 {
 	case 0:
 		if w.ExplicitWireTypes {
-			w.Write("VARINT")
+			w.writeWireType("VARINT")
 		}
 		w.Write(" ")
-		return w.decodeVarint(src, fd)
+		w.classify(field, ClassVarint)
+		if rest, ok := w.decodeVarint(src, fd); ok {
+			if w.AnnotateVarints && fd == nil {
+				_, value, _, _ := decodeVarint(src)
+				w.remarkVarint(value)
+			}
+			return rest, true
+		}
+		return fail()
 
 	case 1:
 		if w.ExplicitWireTypes {
-			w.Write("I64")
+			w.writeWireType("I64")
 		}
 		w.Write(" ")
-		return w.decodeI64(src, fd)
+		w.classify(field, ClassFixed)
+		if rest, ok := w.decodeI64(src, fd); ok {
+			w.remarkHalfFloats(number, src[:8])
+			return rest, true
+		}
+		return fail()
 
 	case 5:
 		if w.ExplicitWireTypes {
-			w.Write("I32")
+			w.writeWireType("I32")
 		}
 		w.Write(" ")
-		return w.decodeI32(src, fd)
+		w.classify(field, ClassFixed)
+		if rest, ok := w.decodeI32(src, fd); ok {
+			w.remarkHalfFloats(number, src[:4])
+			return rest, true
+		}
+		return fail()
 
 	case 3:
+		w.classify(field, ClassMessage)
 		if fd != nil {
 			w.descs.Push(fd.Message())
 		}
 
 		if w.ExplicitWireTypes || w.NoGroups {
-			w.Write("SGROUP")
+			w.writeWireType("SGROUP")
 			w.StartBlock(print.BlockInfo{
 				HasDelimiters:  false,
 				HeightToFoldAt: 2,
@@ -343,11 +1176,11 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 				UnindentAt:     1,
 			})
 		}
-		w.groups.Push(group{number, fd != nil})
+		w.groups.Push(group{number: number, hasDesc: fd != nil})
 
 	case 4:
 		if len(w.groups) == 0 {
-			w.Write("EGROUP")
+			w.writeWireType("EGROUP")
 		} else {
 			lastGroup := w.groups.Pop()
 			if lastGroup.hasDesc {
@@ -356,7 +1189,7 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 
 			if lastGroup.number == number {
 				if w.ExplicitWireTypes || w.NoGroups {
-					w.Write("EGROUP")
+					w.writeWireType("EGROUP")
 				} else {
 					w.Current().Reset()
 					/*if w.PrintFieldNames && fd != nil {
@@ -364,8 +1197,8 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 						w.line(-1).comments = w.line(-1).comments[1:]
 					}*/
 
-					if extra > 0 {
-						w.Writef("long-form:%d", extra)
+					if extra > 0 && !w.MinimalVarints {
+						w.longForm(extra, "")
 						w.NewLine()
 					}
 					w.Write("}")
@@ -373,31 +1206,35 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 				w.EndBlock()
 			} else {
 				w.resetGroup()
-				w.Write("EGROUP")
+				w.writeWireType("EGROUP")
 			}
 		}
 
 	case 2:
 		if w.ExplicitWireTypes || w.ExplicitLengthPrefixes {
-			w.Write("LEN")
+			w.writeWireType("LEN")
 		}
 		w.Write(" ")
 
 		rest, value, extra, ok := decodeVarint(src)
 		if !ok {
-			return nil, false
+			return fail()
+		}
+		if extra > 0 {
+			w.noteIssue(src, "non-minimal length prefix (%d extra bytes)", extra)
 		}
 		src = rest
 
 		if uint64(len(src)) < value {
-			return nil, false
+			return fail()
 		}
 
 		delimited := src[:int(value)]
 		src = src[int(value):]
+		w.classify(field, ClassBytes)
 
 		if extra > 0 {
-			w.Writef("long-form:%d ", extra)
+			w.longForm(extra, " ")
 		}
 		if w.ExplicitLengthPrefixes {
 			w.Write(int64(value))
@@ -407,17 +1244,37 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 				UnindentAt:     0,
 			})
 		} else {
+			// Map entries and well-known types are folded onto one line if each
+			// of their fields fits on one.
+			height := 3
+			if fd != nil && (fd.IsMap() || fd.Message() != nil && isWellKnown(fd.Message().FullName())) {
+				height = 4
+			}
 			w.Write("{")
 			w.StartBlock(print.BlockInfo{
 				HasDelimiters:  true,
-				HeightToFoldAt: 3,
+				HeightToFoldAt: height,
 				UnindentAt:     1,
 			})
 		}
 
 		ftype := protoreflect.MessageKind
+		var msg protoreflect.MessageDescriptor
 		if fd != nil {
 			ftype = fd.Kind()
+			msg = fd.Message()
+		}
+		if w.anyType != nil && inAny && number == 2 {
+			ftype = protoreflect.MessageKind
+			msg = w.anyType
+			if w.PrintFieldNames {
+				w.Remark(string(msg.FullName()))
+			}
+		}
+		if msg != nil {
+			if s := wellKnownRemark(msg.FullName(), delimited); s != "" {
+				w.Remark(s)
+			}
 		}
 
 		decodePacked := func(decode func([]byte, protoreflect.FieldDescriptor) ([]byte, bool)) {
@@ -432,7 +1289,16 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 				delimited = s
 			}
 
-			w.FoldIntoColumns(8, count)
+			if w.CommaSeparated {
+				for i := 1; i < count; i++ {
+					w.Prev(i).WriteString(",")
+				}
+			}
+			if width := w.available(); width > 0 {
+				w.FoldIntoWidth(width, count)
+			} else {
+				w.FoldIntoColumns(8, count)
+			}
 		}
 
 		decodeBytes := func() ([]byte, bool) {
@@ -445,21 +1311,33 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 			return src, true
 		}
 
+		if w.remarkHalfFloats(number, delimited) {
+			return decodeBytes()
+		}
+
+		// Past MaxDepth, don't look inside at all.
+		if w.MaxDepth > 0 && w.depth >= w.MaxDepth {
+			return decodeBytes()
+		}
+
 		switch ftype {
 		case protoreflect.BoolKind, protoreflect.EnumKind,
 			protoreflect.Int32Kind, protoreflect.Int64Kind,
 			protoreflect.Uint32Kind, protoreflect.Uint64Kind,
 			protoreflect.Sint32Kind, protoreflect.Sint64Kind:
+			w.classify(field, ClassVarint)
 			decodePacked(w.decodeVarint)
 			return decodeBytes()
 
 		case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind,
 			protoreflect.FloatKind:
+			w.classify(field, ClassFixed)
 			decodePacked(w.decodeI32)
 			return decodeBytes()
 
 		case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind,
 			protoreflect.DoubleKind:
+			w.classify(field, ClassFixed)
 			decodePacked(w.decodeI64)
 			return decodeBytes()
 
@@ -467,27 +1345,32 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 			goto decodeUtf8
 		}
 
+		if w.MessageRecursionDepth > 0 && w.depth >= w.MessageRecursionDepth {
+			goto decodeUtf8
+		}
+
 		// This is in a block so that the gotos can jump over the declarations
 		// safely.
 		{
 			startLine := w.Mark()
+			startReport := w.reportMark()
 			src2 := delimited
-			outerGroups := w.groups
-			w.groups = nil
-			if fd != nil {
-				w.descs.Push(fd.Message())
+			outerGroups, outerSeen, outerOneofs, outerAny := w.groups, w.seen, w.seenOneofs, w.anyType
+			outerPath := w.path
+			if len(w.FieldNames) > 0 {
+				w.path = w.fieldPath(number) + "."
 			}
-			for len(src2) > 0 {
-				w.NewLine()
-				s, ok := w.decodeField(src2)
-				if !ok {
-					// Clip off an incompletely printed line.
-					w.DiscardLine()
-					break
+			w.groups, w.seen, w.seenOneofs, w.anyType = nil, nil, nil, nil
+			if msg != nil {
+				w.descs.Push(msg)
+				if w.ExpandAny && msg.FullName() == anyName {
+					w.anyType = w.resolveAny(delimited)
 				}
-				src2 = s
 			}
-			if fd != nil {
+			w.depth++
+			src2 = w.decodeFields(src2)
+			w.depth--
+			if msg != nil {
 				w.descs.Pop()
 			}
 
@@ -495,48 +1378,48 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 			for range w.groups {
 				w.resetGroup()
 			}
-			w.groups = outerGroups
+			w.groups, w.seen, w.seenOneofs, w.anyType = outerGroups, outerSeen, outerOneofs, outerAny
+			w.path = outerPath
 
 			// If we consumed all the bytes, we're done and can wrap up. However, if we
 			// consumed *some* bytes, and the user requested unconditional message
 			// parsing, we'll continue regardless. We don't bother in the case where we
 			// failed at the start because the `...` case below will do a cleaner job.
 			if len(src2) == 0 || (w.AllFieldsAreMessages && len(src2) < len(delimited)) {
+				w.classify(field, ClassMessage)
 				delimited = src2
 				return decodeBytes()
 			} else {
 				w.Reset(startLine)
+				w.resetReport(startReport)
 			}
 		}
 
 		// Otherwise, maybe it's a UTF-8 string.
 	decodeUtf8:
-		if !w.NoQuotedStrings && utf8.Valid(delimited) {
-			runes := utf8.RuneCount(delimited)
-
+		if !w.NoQuotedStrings && w.StringThreshold >= 0 && utf8.Valid(delimited) && looksLikeString(delimited, w.stringThreshold()) {
+			w.classify(field, ClassString)
 			s := string(delimited)
-			unprintable := 0
-			for _, r := range s {
-				if !unicode.IsGraphic(r) {
-					unprintable++
-				}
-			}
-			if float64(unprintable)/float64(runes) > 0.3 {
-				return decodeBytes()
-			}
-
 			w.NewLine()
+			w.setColor(colorString)
 			w.Write("\"")
+			width := w.stringWidth()
 			for i, r := range s {
-				if i != 0 && i%80 == 0 {
+				if i != 0 && i%width == 0 {
 					w.Write("\"")
+					w.setColor(colorReset)
 					w.NewLine()
+					w.setColor(colorString)
 					w.Write("\"")
 				}
 
 				switch r {
 				case '\n':
 					w.Write("\\n")
+				case '\t':
+					w.Write("\\t")
+				case '\r':
+					w.Write("\\r")
 				case '\\':
 					w.Write("\\\\")
 				case '"':
@@ -549,22 +1432,309 @@ func (w *writer) decodeField(src []byte) ([]byte, bool) {
 							w.Writef("\\x%02x", b)
 						}
 					} else {
-						w.Writef("%c", r)
+						w.Current().WriteRune(r)
 					}
 				}
 			}
 			w.Write("\"")
+			w.setColor(colorReset)
 			delimited = nil
 		}
 
+		// Maybe it's a packed field?
+		if w.PackedFields && fd == nil {
+			switch typ, ok := guessPacked(delimited); {
+			case !ok:
+			case typ == protowire.VarintType:
+				w.classify(field, ClassVarint)
+				decodePacked(w.decodeVarint)
+			case typ == protowire.Fixed32Type:
+				w.classify(field, ClassFixed)
+				decodePacked(w.decodeI32)
+			case typ == protowire.Fixed64Type:
+				w.classify(field, ClassFixed)
+				decodePacked(w.decodeI64)
+			}
+		}
+
+		if w.DetectUTF16 {
+			if s, ok := decodeUTF16(delimited); ok {
+				w.Remarkf("utf16: %s", strconv.Quote(s))
+			}
+		}
+
 		// Who knows what it is? Bytes or something.
 		return decodeBytes()
 	case 6, 7:
-		return nil, false
+		// These wire types are reserved, so there is no telling how long their
+		// payload is. Inside a length prefix, this probably isn't a message at
+		// all, but at the top level the tag is still worth printing, followed by
+		// the rest of the input as hex.
+		if w.depth > 0 {
+			return fail()
+		}
+		w.setColor(colorWireType)
+		w.Write(value & 0x7)
+		w.setColor(colorReset)
+		w.Remark("reserved wire type")
+		w.dumpHexString(src)
+		return src[len(src):], true
 	}
 	return src, true
 }
 
+// looksLikeString returns whether src is valid UTF-8 that is mostly made up of
+// printable characters.
+func looksLikeString(src []byte, threshold float64) bool {
+	if !utf8.Valid(src) {
+		return false
+	}
+
+	runes := 0
+	unprintable := 0
+	for _, r := range string(src) {
+		runes++
+		if !unicode.IsGraphic(r) {
+			unprintable++
+		}
+	}
+	return unprintable == 0 || float64(unprintable)/float64(runes) <= threshold
+}
+
+// decodeUTF16 decodes src as UTF-16LE text, returning whether it is valid and
+// looks like a string.
+func decodeUTF16(src []byte) (string, bool) {
+	if len(src) == 0 || len(src)%2 != 0 {
+		return "", false
+	}
+
+	units := make([]uint16, len(src)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(src[2*i:])
+	}
+	for i := 0; i < len(units); i++ {
+		// Surrogates must come in high-low pairs.
+		switch u := units[i]; {
+		case 0xd800 <= u && u < 0xdc00:
+			if i+1 == len(units) || units[i+1] < 0xdc00 || units[i+1] >= 0xe000 {
+				return "", false
+			}
+			i++
+		case 0xdc00 <= u && u < 0xe000:
+			return "", false
+		}
+	}
+
+	// Short runs of binary data are easily valid UTF-16, so be much stricter
+	// than with UTF-8 and allow nothing unprintable.
+	s := string(utf16.Decode(units))
+	return s, looksLikeString([]byte(s), -1)
+}
+
+// defaultStringThreshold is the default for WriterOptions.StringThreshold.
+const defaultStringThreshold = 0.3
+
+// stringThreshold returns the largest fraction of unprintable runes allowed in
+// a quoted string.
+func (w *writer) stringThreshold() float64 {
+	if w.StringThreshold == 0 {
+		return defaultStringThreshold
+	}
+	return w.StringThreshold
+}
+
+// anyName is the name of the google.protobuf.Any message type.
+const anyName protoreflect.FullName = "google.protobuf.Any"
+
+// resolveAny finds the message type named by the type URL in src, the contents
+// of an Any, or returns nil if there is no such type.
+func (w *writer) resolveAny(src []byte) protoreflect.MessageDescriptor {
+	var url string
+	for len(src) > 0 {
+		number, typ, n := protowire.ConsumeTag(src)
+		if n < 0 {
+			return nil
+		}
+		src = src[n:]
+		if number == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(src)
+			if n < 0 {
+				return nil
+			}
+			url = string(v)
+		}
+		n = protowire.ConsumeFieldValue(number, typ, src)
+		if n < 0 {
+			return nil
+		}
+		src = src[n:]
+	}
+	if url == "" {
+		return nil
+	}
+
+	files := w.Files
+	if files == nil {
+		files = protoregistry.GlobalFiles
+	}
+	name := url[strings.LastIndexByte(url, '/')+1:]
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil
+	}
+	md, _ := desc.(protoreflect.MessageDescriptor)
+	return md
+}
+
+// isWellKnown returns whether name is one of the well-known types that are
+// printed on one line, with their fields unlabeled.
+func isWellKnown(name protoreflect.FullName) bool {
+	switch name {
+	case "google.protobuf.Timestamp", "google.protobuf.Duration",
+		"google.protobuf.DoubleValue", "google.protobuf.FloatValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value",
+		"google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue",
+		"google.protobuf.BytesValue":
+		return true
+	}
+	return false
+}
+
+// wellKnownRemark returns a human-readable rendering of src, the contents of
+// a Timestamp or Duration named by name, or "" if there is none.
+func wellKnownRemark(name protoreflect.FullName, src []byte) string {
+	if name != "google.protobuf.Timestamp" && name != "google.protobuf.Duration" {
+		return ""
+	}
+
+	// Both types consist of an int64 seconds and an int32 nanos field.
+	var secs, nanos int64
+	for len(src) > 0 {
+		number, typ, n := protowire.ConsumeTag(src)
+		if n < 0 || typ != protowire.VarintType {
+			return ""
+		}
+		src = src[n:]
+		v, n := protowire.ConsumeVarint(src)
+		if n < 0 {
+			return ""
+		}
+		src = src[n:]
+
+		switch number {
+		case 1:
+			secs = int64(v)
+		case 2:
+			nanos = int64(int32(v))
+		default:
+			return ""
+		}
+	}
+
+	if name == "google.protobuf.Timestamp" {
+		if nanos < 0 || nanos >= 1e9 {
+			return ""
+		}
+		return time.Unix(secs, nanos).UTC().Format(time.RFC3339Nano)
+	}
+
+	if nanos <= -1e9 || nanos >= 1e9 || secs < 0 && nanos > 0 || secs > 0 && nanos < 0 {
+		return ""
+	}
+	sign := ""
+	if secs < 0 || nanos < 0 {
+		sign = "-"
+		secs, nanos = -secs, -nanos
+	}
+	if nanos == 0 {
+		return fmt.Sprintf("%s%ds", sign, uint64(secs))
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+	return fmt.Sprintf("%s%d.%ss", sign, uint64(secs), frac)
+}
+
+// typeName returns the name of fd's type, as it would be written in a .proto
+// file.
+func typeName(fd protoreflect.FieldDescriptor) string {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return string(fd.Message().FullName())
+	case protoreflect.EnumKind:
+		return string(fd.Enum().FullName())
+	default:
+		return fd.Kind().String()
+	}
+}
+
+// guessPacked guesses whether src is the contents of a packed repeated field,
+// returning the wire type of its elements if so.
+//
+// Fixed-width elements must all look like floats of a reasonable magnitude,
+// or like small integers; wider elements are preferred over narrower ones.
+// Otherwise, src must consist of minimally-encoded varints.
+func guessPacked(src []byte) (protowire.Type, bool) {
+	if len(src) == 0 {
+		return 0, false
+	}
+	if len(src)%8 == 0 && (looksLikeFloats(src, 8) || looksLikeSmallInts(src, 8)) {
+		return protowire.Fixed64Type, true
+	}
+	if len(src)%4 == 0 && (looksLikeFloats(src, 4) || looksLikeSmallInts(src, 4)) {
+		return protowire.Fixed32Type, true
+	}
+	for len(src) > 0 {
+		rest, _, extra, ok := decodeVarint(src)
+		if !ok || extra > 0 {
+			return 0, false
+		}
+		src = rest
+	}
+	return protowire.VarintType, true
+}
+
+// looksLikeFloats returns whether src is a sequence of little-endian floats of
+// the given size, at least one of which is not zero, and none of which are
+// absurdly large or small.
+func looksLikeFloats(src []byte, size int) bool {
+	nonZero := false
+	for ; len(src) > 0; src = src[size:] {
+		var f float64
+		if size == 4 {
+			f = float64(math.Float32frombits(binary.LittleEndian.Uint32(src)))
+		} else {
+			f = math.Float64frombits(binary.LittleEndian.Uint64(src))
+		}
+		if f == 0 {
+			continue
+		}
+		nonZero = true
+		// This is also false for NaN.
+		if a := math.Abs(f); !(a >= 1e-6 && a <= 1e9) {
+			return false
+		}
+	}
+	return nonZero
+}
+
+// looksLikeSmallInts returns whether src is a sequence of little-endian
+// integers of the given size whose upper bytes are all zero: below 2^24 for
+// 32-bit integers, and below 2^32 for 64-bit ones.
+func looksLikeSmallInts(src []byte, size int) bool {
+	for ; len(src) > 0; src = src[size:] {
+		upper := src[3:4]
+		if size == 8 {
+			upper = src[4:8]
+		}
+		for _, b := range upper {
+			if b != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func ftoa[I uint32 | uint64](bits I, floatForSure bool) string {
 	var mantLen, expLen, bitLen int
 	var value float64
@@ -594,14 +1764,18 @@ func ftoa[I uint32 | uint64](bits I, floatForSure bool) string {
 	}
 	bigExp := int64(1)<<(expLen-1) - 1
 
-	if absExp >= bigExp && !floatForSure {
+	format := byte('g')
+	if absExp >= bigExp {
 		// Very large or very small exponents indicate this probably isn't actually
-		// a float.
-		return ""
+		// a float. If we know that it is, hex makes the exponent easier to read.
+		if !floatForSure {
+			return ""
+		}
+		format = 'x'
 	}
 
 	// Only print floats in decimal if it can be round-tripped.
-	decimal := strconv.FormatFloat(value, 'g', -1, bitLen)
+	decimal := strconv.FormatFloat(value, format, -1, bitLen)
 
 	roundtrip, _ := strconv.ParseFloat(decimal, bitLen)
 	var bits2 I
@@ -621,7 +1795,9 @@ func ftoa[I uint32 | uint64](bits I, floatForSure bool) string {
 
 	// Insert a decimal point if necessary.
 	if !strings.Contains(decimal, ".") {
-		if strings.Contains(decimal, "e") {
+		if strings.Contains(decimal, "p") {
+			decimal = strings.Replace(decimal, "p", ".0p", -1)
+		} else if strings.Contains(decimal, "e") {
 			decimal = strings.Replace(decimal, "e", ".0e", -1)
 		} else {
 			decimal += ".0"
@@ -631,40 +1807,11 @@ func ftoa[I uint32 | uint64](bits I, floatForSure bool) string {
 	return decimal
 }
 
+// decodeVarint decodes a varint from src; see wire.ConsumeVarint.
 func decodeVarint(src []byte) (rest []byte, value uint64, extraBytes int, ok bool) {
-	count := 0
-	for {
-		if len(src) == 0 {
-			ok = false
-			return
-		}
-
-		var b byte
-		b, src = src[0], src[1:]
-		if count == 9 && b > 1 {
-			// The tenth byte has a special upper limit: it may only be 0 or 1.
-			ok = false
-			return
-		}
-
-		value |= uint64(b&0x7f) << (count * 7)
-		count++
-
-		if b&0x7f == 0 {
-			extraBytes++
-		} else {
-			extraBytes = 0
-		}
-
-		if b&0x80 == 0 {
-			break
-		}
-	}
-
-	if value == 0 {
-		extraBytes--
+	value, extraBytes, n, ok := wire.ConsumeVarint(src)
+	if !ok {
+		return nil, 0, 0, false
 	}
-	rest = src
-	ok = true
-	return
+	return src[n:], value, extraBytes, true
 }
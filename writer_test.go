@@ -15,21 +15,29 @@
 package protoscope
 
 import (
+	"bytes"
 	"embed"
 	"fmt"
+	"io/fs"
+	"math"
 	"os"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/protocolbuffers/protoscope/internal/print"
 
 	descpb "google.golang.org/protobuf/types/descriptorpb"
 
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 //go:embed testdata/*
@@ -38,14 +46,30 @@ var testdata embed.FS
 var fileset = ParseFileSet()
 
 func ParseFileSet() *protoregistry.Files {
-	data, err := testdata.ReadFile("testdata/unittest.proto.pb")
+	paths, err := fs.Glob(testdata, "testdata/*.proto.pb")
 	if err != nil {
 		panic(err)
 	}
 
 	fds := new(descpb.FileDescriptorSet)
-	if err := proto.Unmarshal(data, fds); err != nil {
-		panic(err)
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		data, err := testdata.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
+
+		set := new(descpb.FileDescriptorSet)
+		if err := proto.Unmarshal(data, set); err != nil {
+			panic(err)
+		}
+		// Several sets may include the same well-known types.
+		for _, f := range set.File {
+			if !seen[f.GetName()] {
+				seen[f.GetName()] = true
+				fds.File = append(fds.File, f)
+			}
+		}
 	}
 
 	files, err := protodesc.NewFiles(fds)
@@ -68,16 +92,46 @@ func GetDesc(name string) protoreflect.MessageDescriptor {
 	return desc.(protoreflect.MessageDescriptor)
 }
 
-func TestGoldens(t *testing.T) {
-	type golden struct {
-		name   string
-		pb     []byte
-		want   string
-		config string
-		opts   WriterOptions
+// GetExtensions builds a registry of every extension declared in the given file
+// of the test file set.
+func GetExtensions(path string) *protoregistry.Types {
+	file, err := fileset.FindFileByPath(path)
+	if err != nil {
+		panic(err)
 	}
 
-	var tests []golden
+	types := new(protoregistry.Types)
+	var register func(xds protoreflect.ExtensionDescriptors, msgs protoreflect.MessageDescriptors)
+	register = func(xds protoreflect.ExtensionDescriptors, msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < xds.Len(); i++ {
+			if err := types.RegisterExtension(dynamicpb.NewExtensionType(xds.Get(i))); err != nil {
+				panic(err)
+			}
+		}
+		for i := 0; i < msgs.Len(); i++ {
+			register(msgs.Get(i).Extensions(), msgs.Get(i).Messages())
+		}
+	}
+	register(file.Extensions(), file.Messages())
+	return types
+}
+
+// ansiEscape matches the escape codes that WriterOptions.Color adds.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// A golden is a test case from testdata: an input file, the options from the
+// first line of its golden file, and the expected output of Write.
+type golden struct {
+	name   string
+	pb     []byte
+	want   string
+	config string
+	opts   WriterOptions
+}
+
+// readGoldens reads every golden file in testdata.
+func readGoldens(t *testing.T) []golden {
+	var goldens []golden
 	dir, err := testdata.ReadDir("testdata")
 	if err != nil {
 		t.Fatal(err)
@@ -107,15 +161,70 @@ func TestGoldens(t *testing.T) {
 		opts := WriterOptions{}
 		v := reflect.ValueOf(&opts).Elem()
 		for _, opt := range config[1:] {
-			if name := strings.TrimPrefix(opt, "Schema="); name != opt {
-				opts.Schema = GetDesc(name)
+			if opt == "Files" {
+				opts.Files = fileset
+				continue
+			}
+			name, value, ok := strings.Cut(opt, "=")
+			if !ok {
+				v.FieldByName(opt).SetBool(true)
 				continue
 			}
 
-			v.FieldByName(opt).SetBool(true)
+			if name == "Schema" {
+				opts.Schema = GetDesc(value)
+				continue
+			}
+			if name == "ExtensionRegistry" {
+				opts.ExtensionRegistry = GetExtensions(value)
+				continue
+			}
+
+			switch f := v.FieldByName(name); f.Kind() {
+			case reflect.Int:
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					t.Fatalf("%s: %s", d.Name(), err)
+				}
+				f.SetInt(int64(n))
+			case reflect.Float64:
+				x, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					t.Fatalf("%s: %s", d.Name(), err)
+				}
+				f.SetFloat(x)
+			case reflect.Slice:
+				// Slices of ints are comma-separated.
+				var ns []int
+				for _, s := range strings.Split(value, ",") {
+					n, err := strconv.Atoi(s)
+					if err != nil {
+						t.Fatalf("%s: %s", d.Name(), err)
+					}
+					ns = append(ns, n)
+				}
+				f.Set(reflect.ValueOf(ns))
+			case reflect.Map:
+				// Maps of strings are comma-separated key:value pairs.
+				m := make(map[string]string)
+				for _, kv := range strings.Split(value, ",") {
+					k, v, _ := strings.Cut(kv, ":")
+					m[k] = v
+				}
+				f.Set(reflect.ValueOf(m))
+			case reflect.String:
+				// Strings are quoted, and must escape any spaces.
+				s, err := strconv.Unquote(value)
+				if err != nil {
+					t.Fatalf("%s: %s", d.Name(), err)
+				}
+				f.SetString(s)
+			default:
+				t.Fatalf("%s: cannot set option %s", d.Name(), name)
+			}
 		}
 
-		tests = append(tests, golden{
+		goldens = append(goldens, golden{
 			name:   d.Name(),
 			pb:     pb,
 			want:   goldenText,
@@ -124,6 +233,12 @@ func TestGoldens(t *testing.T) {
 		})
 	}
 
+	return goldens
+}
+
+func TestGoldens(t *testing.T) {
+	tests := readGoldens(t)
+
 	if _, ok := os.LookupEnv("REGEN_GOLDENS"); ok {
 		for _, tt := range tests {
 			got := Write(tt.pb, tt.opts)
@@ -142,6 +257,358 @@ func TestGoldens(t *testing.T) {
 			if d := cmp.Diff(tt.want, got); d != "" {
 				t.Fatal("output mismatch (-want, +got):", d)
 			}
+
+			// Color must not change anything but the escape codes.
+			opts := tt.opts
+			opts.Color = true
+			colored := Write(tt.pb, opts)
+			if !strings.Contains(colored, "\x1b[") && strings.ContainsAny(tt.want, ":#") {
+				t.Error("Color added no escape codes")
+			}
+			if d := cmp.Diff(tt.want, ansiEscape.ReplaceAllString(colored, "")); d != "" {
+				t.Fatal("colored output mismatch (-want, +got):", d)
+			}
+
+			// Neither must CRLF, other than the line endings.
+			opts = tt.opts
+			opts.CRLF = true
+			crlf := Write(tt.pb, opts)
+			if d := cmp.Diff(strings.ReplaceAll(tt.want, "\n", "\r\n"), crlf); d != "" {
+				t.Fatal("CRLF output mismatch (-want, +got):", d)
+			}
+		})
+	}
+}
+
+func TestNaNRoundTrip(t *testing.T) {
+	// Fields 11 and 12 are the float and double fields of TestAllTypes.
+	//
+	// Every NaN has an all-ones exponent and a nonzero mantissa, which may be
+	// any payload. Try both signs and a spread of payloads, including the
+	// smallest and largest.
+	payloads32 := []uint32{1, 2, 0x1, 0x3fffff, 0x400000, 0x400001, 0x7fffff, 0x123456, 0x555555, 0x2aaaaa}
+	payloads64 := []uint64{1, 2, 0x7ffffffffffff, 0x8000000000000, 0x8000000000001, 0xfffffffffffff, 0x123456789abcd, 0x5555555555555}
+	for i := uint32(1); i < 1<<23; i = i*3 + 1 {
+		payloads32 = append(payloads32, i)
+	}
+	for i := uint64(1); i < 1<<52; i = i*5 + 3 {
+		payloads64 = append(payloads64, i)
+	}
+
+	var want []byte
+	for _, sign := range []uint32{0, 1 << 31} {
+		for _, p := range payloads32 {
+			bits := sign | 0x7f800000 | p
+			if !math.IsNaN(float64(math.Float32frombits(bits))) {
+				t.Fatalf("%#x is not a NaN", bits)
+			}
+			want = protowire.AppendTag(want, 11, protowire.Fixed32Type)
+			want = protowire.AppendFixed32(want, bits)
+		}
+	}
+	for _, sign := range []uint64{0, 1 << 63} {
+		for _, p := range payloads64 {
+			bits := sign | 0x7ff0000000000000 | p
+			if !math.IsNaN(math.Float64frombits(bits)) {
+				t.Fatalf("%#x is not a NaN", bits)
+			}
+			want = protowire.AppendTag(want, 12, protowire.Fixed64Type)
+			want = protowire.AppendFixed64(want, bits)
+		}
+	}
+
+	for _, opts := range []WriterOptions{
+		{},
+		{Schema: GetDesc("unittest.TestAllTypes")},
+	} {
+		text := Write(want, opts)
+		got, err := NewScanner(text).Exec()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("NaNs did not round-trip (-want, +got): %s", d)
+		}
+	}
+}
+
+func TestSubnormalRoundTrip(t *testing.T) {
+	// With a schema, subnormals in fields 11 and 12, the float and double
+	// fields of TestAllTypes, are printed as hex floats rather than integers.
+	var want []byte
+	for _, bits := range []uint32{1, 2, 0x7fffff, 0x80000001, 0x400000} {
+		want = protowire.AppendTag(want, 11, protowire.Fixed32Type)
+		want = protowire.AppendFixed32(want, bits)
+	}
+	for _, bits := range []uint64{1, 3, 0xfffffffffffff, 0x8000000000000001, 0x8000000000000} {
+		want = protowire.AppendTag(want, 12, protowire.Fixed64Type)
+		want = protowire.AppendFixed64(want, bits)
+	}
+
+	text := Write(want, WriterOptions{Schema: GetDesc("unittest.TestAllTypes")})
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if !strings.Contains(line, "p-") {
+			t.Errorf("not printed as a hex float: %q", line)
+		}
+	}
+
+	got, err := NewScanner(text).Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("subnormals did not round-trip (-want, +got): %s\n%s", d, text)
+	}
+}
+
+func TestMinimalVarints(t *testing.T) {
+	// Non-minimal tags, varints, and length prefixes should be reassembled in
+	// their minimal form, and the default should keep them as they are.
+	tests := []struct {
+		name, text, want string
+	}{
+		{"varint", "1: long-form:3 5", "1: 5"},
+		{"tag", "long-form:2 1: 5", "1: 5"},
+		{"length prefix", `2: long-form:1 {"hello"}`, `2: {"hello"}`},
+		{"nested", "3: {long-form:4 4: long-form:1 {5: long-form:2 6}}", "3: {4: {5: 6}}"},
+		{"group", "7: !{8: long-form:1 9 long-form:1}", "7: !{8: 9}"},
+		{"already minimal", "1: 300 2: {3: 4}", "1: 300 2: {3: 4}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := NewScanner(tt.want).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			text := Write(src, WriterOptions{MinimalVarints: true})
+			got, err := NewScanner(text).Exec()
+			if err != nil {
+				t.Fatalf("%s\n%s", err, text)
+			}
+			if d := cmp.Diff(want, got); d != "" {
+				t.Errorf("not minimal (-want, +got): %s\n%s", d, text)
+			}
+
+			if normalized := Write(src, WriterOptions{NormalizeVarints: true}); normalized != text {
+				t.Errorf("NormalizeVarints output differs from MinimalVarints:\n%s\n%s", normalized, text)
+			}
+
+			text = Write(src, WriterOptions{})
+			got, err = NewScanner(text).Exec()
+			if err != nil {
+				t.Fatalf("%s\n%s", err, text)
+			}
+			if d := cmp.Diff(src, got); d != "" {
+				t.Errorf("did not round-trip by default (-want, +got): %s\n%s", d, text)
+			}
+		})
+	}
+}
+
+func TestEmptyGroups(t *testing.T) {
+	// Empty groups and empty messages have different encodings, and must each
+	// be printed as themselves, whether or not groups are written with
+	// explicit SGROUP and EGROUP tags.
+	tests := []struct {
+		name, text               string
+		want, noGroups, explicit string
+	}{
+		{
+			name: "group", text: "1: !{}",
+			want: "1: !{}", noGroups: "1:SGROUP 1:EGROUP", explicit: "1:SGROUP 1:EGROUP",
+		},
+		{
+			name: "message", text: "1: {}",
+			want: "1: {}", noGroups: "1: {}", explicit: "1:LEN {}",
+		},
+		{
+			name: "both", text: "1: !{} 2: {}",
+			want: "1: !{}\n2: {}", noGroups: "1:SGROUP 1:EGROUP\n2: {}", explicit: "1:SGROUP 1:EGROUP\n2:LEN {}",
+		},
+		{
+			name: "group in message", text: "1: {2: !{}}",
+			want: "1: {2: !{}}", noGroups: "1: {2:SGROUP 2:EGROUP}", explicit: "1:LEN {2:SGROUP 2:EGROUP}",
+		},
+		{
+			name: "message in group", text: "1: !{2: {}}",
+			want: "1: !{2: {}}", noGroups: "1:SGROUP\n  2: {}\n1:EGROUP", explicit: "1:SGROUP\n  2:LEN {}\n1:EGROUP",
+		},
+		{
+			name: "unclosed", text: "1:SGROUP",
+			want: "1:SGROUP", noGroups: "1:SGROUP", explicit: "1:SGROUP",
+		},
+		{
+			name: "mismatched", text: "1:SGROUP 2:EGROUP",
+			want: "1:SGROUP\n2:EGROUP", noGroups: "1:SGROUP\n2:EGROUP", explicit: "1:SGROUP\n2:EGROUP",
+		},
+		{
+			name: "unclosed in group", text: "1: !{2:SGROUP}",
+			want: "1:SGROUP\n2:SGROUP\n1:EGROUP", noGroups: "1:SGROUP\n2:SGROUP\n1:EGROUP", explicit: "1:SGROUP\n2:SGROUP\n1:EGROUP",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, out := range []struct {
+				name string
+				opts WriterOptions
+				want string
+			}{
+				{"default", WriterOptions{}, tt.want},
+				{"NoGroups", WriterOptions{NoGroups: true}, tt.noGroups},
+				{"ExplicitWireTypes", WriterOptions{ExplicitWireTypes: true}, tt.explicit},
+			} {
+				text := Write(src, out.opts)
+				if d := cmp.Diff(out.want+"\n", text); d != "" {
+					t.Errorf("%s: output mismatch (-want, +got): %s", out.name, d)
+				}
+
+				got, err := NewScanner(text).Exec()
+				if err != nil {
+					t.Fatalf("%s: %s\n%s", out.name, err, text)
+				}
+				if d := cmp.Diff(src, got); d != "" {
+					t.Errorf("%s: did not round-trip (-want, +got): %s\n%s", out.name, d, text)
+				}
+			}
+		})
+	}
+}
+
+func TestUnclosedGroups(t *testing.T) {
+	// Groups that are never closed are printed with an SGROUP in place of the
+	// !{ that opened them, wherever that !{ ended up.
+	tests := []struct {
+		name, text, want string
+	}{
+		{"top level", "1:SGROUP 2: 3", "1:SGROUP\n2: 3"},
+		{"folded message", "1: {2:SGROUP}", "1: {2:SGROUP}"},
+		{"message", "1: {2:SGROUP 3: 4}", "1: {\n  2:SGROUP\n  3: 4\n}"},
+		{"nested messages", "1: {2: {3:SGROUP 4: 5}} 6: 7", "1: {\n  2: {\n    3:SGROUP\n    4: 5\n  }\n}\n6: 7"},
+		{"nested groups", "1: !{2: !{3:SGROUP}}", "1:SGROUP\n2:SGROUP\n3:SGROUP\n2:EGROUP\n1:EGROUP"},
+		{"closed sibling", "1:SGROUP 2: !{3: 4} 5: {6:SGROUP}", "1:SGROUP\n2: !{3: 4}\n5: {6:SGROUP}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewScanner(tt.text).Exec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			text := Write(src, WriterOptions{})
+			if d := cmp.Diff(tt.want+"\n", text); d != "" {
+				t.Errorf("output mismatch (-want, +got): %s", d)
+			}
+			got, err := NewScanner(text).Exec()
+			if err != nil {
+				t.Fatalf("%s\n%s", err, text)
+			}
+			if d := cmp.Diff(src, got); d != "" {
+				t.Errorf("did not round-trip (-want, +got): %s\n%s", d, text)
+			}
+
+			colored := Write(src, WriterOptions{Color: true})
+			if d := cmp.Diff(text, ansiEscape.ReplaceAllString(colored, "")); d != "" {
+				t.Errorf("colored output mismatch (-want, +got): %s", d)
+			}
+		})
+	}
+
+	// The !{ need not be at the end of its line, such as when the start of the
+	// group's contents was folded onto it.
+	w := writer{}
+	w.NewLine()
+	w.Write("1: !{")
+	w.StartBlock(print.BlockInfo{HasDelimiters: true, HeightToFoldAt: 3, UnindentAt: 1})
+	w.Current().WriteString("2: 3")
+	w.resetGroup()
+	if got, want := string(w.Finish()), "1:SGROUP 2: 3\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAppendWrite(t *testing.T) {
+	pb, err := testdata.ReadFile("testdata/message.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := WriterOptions{Schema: GetDesc("unittest.TestAllTypes")}
+	want := Write(pb, opts)
+
+	buf := []byte("prefix\n")
+	buf = AppendWrite(buf, pb, opts)
+	if d := cmp.Diff("prefix\n"+want, string(buf)); d != "" {
+		t.Errorf("output mismatch (-want, +got): %s", d)
+	}
+	buf = AppendWrite(buf[:0], pb, opts)
+	if d := cmp.Diff(want, string(buf)); d != "" {
+		t.Errorf("output mismatch after reuse (-want, +got): %s", d)
+	}
+}
+
+var benchMessage = func() []byte {
+	pb, err := NewScanner(benchInput).Exec()
+	if err != nil {
+		panic(err)
+	}
+	return pb
+}()
+
+func BenchmarkWrite(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = Write(benchMessage, WriterOptions{})
+	}
+}
+
+func BenchmarkAppendWrite(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		buf = AppendWrite(buf[:0], benchMessage, WriterOptions{})
+	}
+}
+
+func BenchmarkWriteLarge(b *testing.B) {
+	// Each input is a golden's input repeated to make a message of a few
+	// hundred kilobytes, disassembled with that golden's options.
+	benches := []struct {
+		name, pb string
+		opts     WriterOptions
+	}{
+		{"message", "message.pb", WriterOptions{}},
+		{"schema", "message.pb", WriterOptions{Schema: GetDesc("unittest.TestAllTypes"), PrintFieldNames: true}},
+		{"packed", "packed-big.pb", WriterOptions{Schema: GetDesc("unittest.TestPackedTypes")}},
+		{"strings", "strings.pb", WriterOptions{}},
+		{"hex", "hex.pb", WriterOptions{}},
+	}
+
+	for _, bb := range benches {
+		b.Run(bb.name, func(b *testing.B) {
+			pb, err := testdata.ReadFile("testdata/" + bb.pb)
+			if err != nil {
+				b.Fatal(err)
+			}
+			src := bytes.Repeat(pb, 256*1024/len(pb)+1)
+
+			b.SetBytes(int64(len(src)))
+			b.ReportAllocs()
+			var buf []byte
+			for i := 0; i < b.N; i++ {
+				buf = AppendWrite(buf[:0], src, bb.opts)
+			}
 		})
 	}
 }